@@ -0,0 +1,303 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultMaxArchiveEntries   = 10000
+	defaultMaxArchiveSize      = defaultMaxFileSize
+	defaultMaxCompressionRatio = 100
+)
+
+const (
+	LimitArchiveEntries   UploadLimit = "MaxArchiveEntries"
+	LimitArchiveSize      UploadLimit = "MaxArchiveSize"
+	LimitCompressionRatio UploadLimit = "MaxCompressionRatio"
+)
+
+// ArchiveEntryError is returned when an archive contains an entry that cannot be extracted safely
+type ArchiveEntryError struct {
+	Entry  string
+	Reason string
+}
+
+// Error implements the error interface
+func (e *ArchiveEntryError) Error() string {
+	return fmt.Sprintf("archive entry %q cannot be extracted: %s", e.Entry, e.Reason)
+}
+
+// ExtractArchive extracts the ZIP or TAR archive stored as archiveName, which may be gzip
+// compressed, into destDir. Entries that would end up outside destDir, links and special files
+// are refused, and MaxArchiveEntries, MaxArchiveSize and MaxCompressionRatio are enforced while
+// reading. Every file goes through the same checks as an upload, keeping its sanitized name and
+// directory. Either all files are extracted, or none are.
+func (t *Tools) ExtractArchive(archiveName, destDir string) ([]*UploadedFile, error) {
+	store := t.storage()
+
+	info, err := store.Stat(archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := store.Get(archiveName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	header = header[:n]
+	src := io.MultiReader(bytes.NewReader(header), file)
+
+	if t.Storage == nil {
+		if err := t.CreateDirIfNotExist(destDir); err != nil {
+			return nil, err
+		}
+	}
+
+	x := &archiveExtractor{
+		tools:       t,
+		destDir:     destDir,
+		batch:       &uploadBatch{atomic: true},
+		archiveSize: max(info.Size(), 1),
+	}
+
+	switch mediaType(t.DetectFileType(header)) {
+	case "application/zip":
+		err = x.extractZip(file, src, info.Size())
+	case "application/x-gzip":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(src); err == nil {
+			err = x.extractTar(gz)
+		}
+	case "application/x-tar":
+		err = x.extractTar(src)
+	default:
+		return nil, fmt.Errorf("file %q is not a ZIP or TAR archive", archiveName)
+	}
+
+	if err != nil {
+		t.discardBatch(x.batch, err)
+		t.uploadComplete(x.batch, nil, err)
+		return nil, err
+	}
+
+	if err := t.commitBatch(x.batch); err != nil {
+		t.uploadComplete(x.batch, nil, err)
+		return nil, err
+	}
+	t.uploadComplete(x.batch, x.files, nil)
+
+	return x.files, nil
+}
+
+// maxArchiveEntries returns the configured entry limit, or the default
+func (t *Tools) maxArchiveEntries() int {
+	if t.MaxArchiveEntries == 0 {
+		return defaultMaxArchiveEntries
+	}
+
+	return t.MaxArchiveEntries
+}
+
+// maxArchiveSize returns the configured limit on the uncompressed size of an archive, or the default
+func (t *Tools) maxArchiveSize() int64 {
+	if t.MaxArchiveSize == 0 {
+		return defaultMaxArchiveSize
+	}
+
+	return t.MaxArchiveSize
+}
+
+// maxCompressionRatio returns the configured compression ratio limit, or the default
+func (t *Tools) maxCompressionRatio() int64 {
+	if t.MaxCompressionRatio == 0 {
+		return defaultMaxCompressionRatio
+	}
+
+	return int64(t.MaxCompressionRatio)
+}
+
+// archiveExtractor holds the state of a single ExtractArchive call
+type archiveExtractor struct {
+	tools       *Tools
+	destDir     string
+	batch       *uploadBatch
+	archiveSize int64
+	entries     int
+	total       int64
+	files       []*UploadedFile
+}
+
+// extractZip extracts a ZIP archive of the given size. ZIP needs random access, so archives
+// whose storage does not provide it are copied to a temporary file first.
+func (x *archiveExtractor) extractZip(file io.Reader, src io.Reader, size int64) error {
+	r, size, cleanup, err := readerAt(file, src, size)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	ratio := x.tools.maxCompressionRatio()
+	for _, f := range zr.File {
+		dir, base, err := x.entry(f.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			continue
+		case mode&os.ModeSymlink != 0:
+			return &ArchiveEntryError{Entry: f.Name, Reason: "links are not allowed"}
+		case !mode.IsRegular():
+			return &ArchiveEntryError{Entry: f.Name, Reason: "not a regular file"}
+		}
+
+		// reject entries that claim a suspicious ratio before inflating them; the limit is
+		// enforced again while reading in case the sizes lie
+		maxRead := ratio * max(int64(f.CompressedSize64), 1)
+		if f.UncompressedSize64 > uint64(maxRead) {
+			return &UploadLimitError{FileName: f.Name, Limit: LimitCompressionRatio, Max: ratio}
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = x.extractFile(f.Name, dir, base, rc, maxRead)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTar extracts an uncompressed TAR stream
+func (x *archiveExtractor) extractTar(src io.Reader) error {
+	tr := tar.NewReader(src)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// pax global headers, such as the commit ID written by git archive, only carry metadata
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		dir, base, err := x.entry(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case hdr.Typeflag == tar.TypeDir:
+			continue
+		case hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink:
+			return &ArchiveEntryError{Entry: hdr.Name, Reason: "links are not allowed"}
+		case !hdr.FileInfo().Mode().IsRegular():
+			return &ArchiveEntryError{Entry: hdr.Name, Reason: "not a regular file"}
+		}
+
+		if err := x.extractFile(hdr.Name, dir, base, tr, -1); err != nil {
+			return err
+		}
+	}
+}
+
+// entry counts an archive entry and splits its name into a sanitized directory and a base name,
+// refusing names that would escape the destination directory
+func (x *archiveExtractor) entry(name string) (string, string, error) {
+	x.entries++
+	if limit := x.tools.maxArchiveEntries(); x.entries > limit {
+		return "", "", &UploadLimitError{FileName: name, Limit: LimitArchiveEntries, Max: int64(limit)}
+	}
+
+	clean := strings.ReplaceAll(name, `\`, "/")
+	if !filepath.IsLocal(filepath.FromSlash(clean)) || path.IsAbs(clean) {
+		return "", "", &ArchiveEntryError{Entry: name, Reason: "path escapes the destination directory"}
+	}
+	clean = path.Clean(clean)
+
+	var dirs []string
+	if dir := path.Dir(clean); dir != "." {
+		for _, part := range strings.Split(dir, "/") {
+			dirs = append(dirs, x.tools.SanitizeFileName(part))
+		}
+	}
+
+	return filepath.Join(dirs...), path.Base(clean), nil
+}
+
+// extractFile saves one file of the archive. maxRead limits the bytes read from r, or is negative.
+func (x *archiveExtractor) extractFile(name, dir, base string, r io.Reader, maxRead int64) error {
+	x.batch.subdir = dir
+
+	src := &archiveReader{src: r, extractor: x, name: name, maxRead: maxRead}
+	uploadedFile, err := x.tools.saveFile(src, "", &multipart.FileHeader{Filename: base}, x.destDir, false, x.batch)
+	if err != nil {
+		return err
+	}
+	x.files = append(x.files, uploadedFile)
+
+	return nil
+}
+
+// archiveReader enforces the size and compression ratio limits while an entry is read
+type archiveReader struct {
+	src       io.Reader
+	extractor *archiveExtractor
+	name      string
+	maxRead   int64
+	read      int64
+}
+
+// Read implements io.Reader
+func (a *archiveReader) Read(p []byte) (int, error) {
+	n, err := a.src.Read(p)
+	a.read += int64(n)
+
+	x := a.extractor
+	x.total += int64(n)
+
+	if limit := x.tools.maxArchiveSize(); x.total > limit {
+		return n, &UploadLimitError{FileName: a.name, Limit: LimitArchiveSize, Max: limit}
+	}
+
+	ratio := x.tools.maxCompressionRatio()
+	if (a.maxRead >= 0 && a.read > a.maxRead) || x.total > ratio*x.archiveSize {
+		return n, &UploadLimitError{FileName: a.name, Limit: LimitCompressionRatio, Max: ratio}
+	}
+
+	return n, err
+}
@@ -0,0 +1,219 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testArchiveEntry is an entry written by testArchive
+type testArchiveEntry struct {
+	name    string
+	content []byte
+	link    bool
+	global  bool // a pax global header in a TAR, the archive comment in a ZIP
+}
+
+// testArchive returns a ZIP, or a gzip compressed TAR, archive holding entries
+func testArchive(t *testing.T, format string, entries ...testArchiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	if format == "zip" {
+		writer := zip.NewWriter(&buf)
+		for _, entry := range entries {
+			if entry.global {
+				if err := writer.SetComment(string(entry.content)); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			if entry.link {
+				header.SetMode(os.ModeSymlink | 0o777)
+			}
+			f, err := writer.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(entry.content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	gz := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gz)
+	for _, entry := range entries {
+		if entry.global {
+			header := &tar.Header{Name: entry.name, Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": string(entry.content)}}
+			if err := writer.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if entry.link {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, "/etc/passwd", 0
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(entry.content); !entry.link && err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var bombBytes = append(append([]byte{}, pdfBytes...), make([]byte, 1<<20)...)
+
+var extractArchiveTests = []struct {
+	name          string
+	tools         Tools
+	entries       []testArchiveEntry
+	expectedPaths []string
+	errorExpected interface{}
+}{
+	{
+		name:          "files and directories",
+		entries:       []testArchiveEntry{{name: "a.pdf", content: pdfBytes}, {name: "docs/b.pdf", content: pdfBytes}},
+		expectedPaths: []string{"a.pdf", "docs/b.pdf"},
+	},
+	{
+		name:          "git archive",
+		entries:       []testArchiveEntry{{name: "pax_global_header", content: []byte("0123456789abcdef0123456789abcdef01234567"), global: true}, {name: "repo/a.pdf", content: pdfBytes}},
+		expectedPaths: []string{"repo/a.pdf"},
+	},
+	{name: "zip slip", entries: []testArchiveEntry{{name: "../evil.pdf", content: pdfBytes}}, errorExpected: &ArchiveEntryError{}},
+	{name: "absolute path", entries: []testArchiveEntry{{name: "/tmp/evil.pdf", content: pdfBytes}}, errorExpected: &ArchiveEntryError{}},
+	{name: "symlink", entries: []testArchiveEntry{{name: "link.pdf", content: []byte("/etc/passwd"), link: true}}, errorExpected: &ArchiveEntryError{}},
+	{
+		name:          "too many entries",
+		tools:         Tools{MaxArchiveEntries: 1},
+		entries:       []testArchiveEntry{{name: "a.pdf", content: pdfBytes}, {name: "b.pdf", content: pdfBytes}},
+		errorExpected: &UploadLimitError{Limit: LimitArchiveEntries},
+	},
+	{
+		name:          "too large",
+		tools:         Tools{MaxArchiveSize: 1500},
+		entries:       []testArchiveEntry{{name: "a.pdf", content: pdfBytes}, {name: "b.pdf", content: pdfBytes}},
+		errorExpected: &UploadLimitError{Limit: LimitArchiveSize},
+	},
+	{
+		name:          "compression bomb",
+		entries:       []testArchiveEntry{{name: "bomb.pdf", content: bombBytes}},
+		errorExpected: &UploadLimitError{Limit: LimitCompressionRatio},
+	},
+	{
+		name:          "disallowed type",
+		entries:       []testArchiveEntry{{name: "a.pdf", content: pdfBytes}, {name: "notes.txt", content: []byte("plain text")}},
+		errorExpected: &FileTypeError{},
+	},
+}
+
+func TestTools_ExtractArchive(t *testing.T) {
+	for _, entry := range extractArchiveTests {
+		for _, format := range []string{"zip", "tar.gz"} {
+			testTools := entry.tools
+			destDir := t.TempDir()
+
+			archiveName := filepath.Join(t.TempDir(), "bundle."+format)
+			if err := os.WriteFile(archiveName, testArchive(t, format, entry.entries...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			uploadedFiles, err := testTools.ExtractArchive(archiveName, destDir)
+
+			if entry.errorExpected != nil {
+				switch expected := entry.errorExpected.(type) {
+				case *ArchiveEntryError:
+					var entryErr *ArchiveEntryError
+					if !errors.As(err, &entryErr) {
+						t.Errorf("%s (%s): expected ArchiveEntryError, got %v", entry.name, format, err)
+					}
+				case *UploadLimitError:
+					var limitErr *UploadLimitError
+					if !errors.As(err, &limitErr) || limitErr.Limit != expected.Limit {
+						t.Errorf("%s (%s): expected %s error, got %v", entry.name, format, expected.Limit, err)
+					}
+				case *FileTypeError:
+					var typeErr *FileTypeError
+					if !errors.As(err, &typeErr) {
+						t.Errorf("%s (%s): expected FileTypeError, got %v", entry.name, format, err)
+					}
+				}
+
+				// nothing is kept from a failed extraction
+				files, _ := filepath.Glob(filepath.Join(destDir, "*"))
+				if len(files) != 0 {
+					t.Errorf("%s (%s): expected nothing extracted, found %v", entry.name, format, files)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s (%s): %v", entry.name, format, err)
+				continue
+			}
+
+			if len(uploadedFiles) != len(entry.expectedPaths) {
+				t.Errorf("%s (%s): expected %d files, got %d", entry.name, format, len(entry.expectedPaths), len(uploadedFiles))
+				continue
+			}
+
+			for i, expected := range entry.expectedPaths {
+				if uploadedFiles[i].Path != expected {
+					t.Errorf("%s (%s): expected path %s, got %s", entry.name, format, expected, uploadedFiles[i].Path)
+				}
+				if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(expected))); err != nil {
+					t.Errorf("%s (%s): %v", entry.name, format, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_ExtractArchiveNotAnArchive(t *testing.T) {
+	var testTools Tools
+
+	if _, err := testTools.ExtractArchive("./testdata/tipfinger.jpg", t.TempDir()); err == nil {
+		t.Error("expected error for a file that is not an archive")
+	}
+}
+
+func TestTools_ExtractArchiveMemoryStorage(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+
+	if _, err := store.Put("bundle.zip", bytes.NewReader(testArchive(t, "zip", testArchiveEntry{name: "docs/a.pdf", content: pdfBytes}))); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadedFiles, err := testTools.ExtractArchive("bundle.zip", "extracted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Stat(filepath.Join("extracted", "docs", "a.pdf")); err != nil || uploadedFiles[0].Path != "docs/a.pdf" {
+		t.Errorf("expected docs/a.pdf to be extracted: %v", err)
+	}
+}
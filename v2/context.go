@@ -0,0 +1,88 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// ProgressFunc is called as a file is written, with the number of bytes of fileName written so far.
+// With UploadWorkers set it may be called from several goroutines at once.
+type ProgressFunc func(fileName string, written int64)
+
+// UploadFilesContext uploads files like UploadFiles, but stops as soon as ctx is done, usually
+// because the client behind r.Context() went away. The file being written when that happens is
+// removed, as is the whole batch if AtomicUploads is set. If progress is not nil it is called
+// as each file is written.
+func (t *Tools) UploadFilesContext(ctx context.Context, r *http.Request, uploadDir string, progress ProgressFunc, rename ...bool) ([]*UploadedFile, error) {
+	renameFile := true
+
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return t.UploadFilesWithOptions(r, uploadDir, UploadOptions{KeepFileName: !renameFile, Context: ctx, Progress: progress})
+}
+
+// HandleFileContext processes a single file like HandleFile, stopping and removing the partial
+// file when ctx is done. If progress is not nil it is called as the file is written.
+func (t *Tools) HandleFileContext(ctx context.Context, fileHeader *multipart.FileHeader, uploadDir string, renameFile bool, progress ProgressFunc) (*UploadedFile, error) {
+	batch := &uploadBatch{ctx: ctx, progress: progress}
+	uploadedFile, err := t.handleFile("", fileHeader, uploadDir, renameFile, batch)
+
+	return t.completeFile(batch, uploadedFile, err)
+}
+
+// err returns the error of the batch's context, if it is done
+func (b *uploadBatch) err() error {
+	if b.ctx == nil {
+		return nil
+	}
+
+	return b.ctx.Err()
+}
+
+// context returns the context of the batch, falling back to the context of its request
+func (b *uploadBatch) context() context.Context {
+	if b.ctx != nil {
+		return b.ctx
+	}
+	if b.request != nil {
+		return b.request.Context()
+	}
+
+	return context.Background()
+}
+
+// wrapReader makes reads from src fail once the batch's context is done, and reports progress
+func (b *uploadBatch) wrapReader(src io.Reader, fileName string) io.Reader {
+	if b.ctx == nil && b.progress == nil {
+		return src
+	}
+
+	return &progressReader{src: src, batch: b, fileName: fileName}
+}
+
+// progressReader is the reader returned by wrapReader
+type progressReader struct {
+	src      io.Reader
+	batch    *uploadBatch
+	fileName string
+	written  int64
+}
+
+// Read implements io.Reader
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.batch.err(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.src.Read(b)
+	if n > 0 && p.batch.progress != nil {
+		p.written += int64(n)
+		p.batch.progress(p.fileName, p.written)
+	}
+
+	return n, err
+}
@@ -0,0 +1,103 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// cancelReader cancels a context once more than after bytes have been read through it
+type cancelReader struct {
+	r      io.Reader
+	after  int
+	read   int
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.read += n
+	if c.read > c.after {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestTools_UploadFilesContextProgress(t *testing.T) {
+	var testTools Tools
+
+	content := readTestFile(t, "cyborg-ape.png")
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "ape.png", content: content})
+
+	var calls int
+	var written int64
+	progress := func(fileName string, n int64) {
+		if fileName != "ape.png" {
+			t.Errorf("unexpected file name %s", fileName)
+		}
+		if n < written {
+			t.Errorf("progress went backwards from %d to %d", written, n)
+		}
+		calls++
+		written = n
+	}
+
+	uploadedFiles, err := testTools.UploadFilesContext(context.Background(), request, t.TempDir(), progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls == 0 {
+		t.Error("expected progress to be reported")
+	}
+
+	if written != int64(len(content)) || uploadedFiles[0].FileSize != written {
+		t.Errorf("expected %d bytes written, got %d", len(content), written)
+	}
+}
+
+func TestTools_UploadFilesContextCancel(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		testTools := Tools{StreamUploads: stream}
+		uploadDir := t.TempDir()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")})
+		request.Body = io.NopCloser(&cancelReader{r: request.Body, after: 4096, cancel: cancel})
+
+		_, err := testTools.UploadFilesContext(ctx, request, uploadDir, nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("stream %v: expected context.Canceled, got %v", stream, err)
+		}
+
+		entries, _ := os.ReadDir(uploadDir)
+		if len(entries) != 0 {
+			t.Errorf("stream %v: expected partial files to be removed, found %d", stream, len(entries))
+		}
+	}
+}
+
+func TestTools_HandleFileContext(t *testing.T) {
+	var testTools Tools
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")})
+	if err := request.ParseMultipartForm(multipartMaxMemory); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	uploadDir := t.TempDir()
+	_, err := testTools.HandleFileContext(ctx, request.MultipartForm.File["file"][0], uploadDir, true, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(uploadDir)
+	if len(entries) != 0 {
+		t.Errorf("expected no files, found %d", len(entries))
+	}
+}
@@ -0,0 +1,183 @@
+package toolkit
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	derivativeJPEGQuality      = 85         // quality used when encoding JPEG derivatives
+	defaultMaxDerivativePixels = 40_000_000 // MaxImagePixels used when Derivatives are set
+)
+
+// Derivative describes a scaled copy of an uploaded image, e.g. {Name: "thumb", MaxSize: 128}.
+// The image is scaled so that its longest side is at most MaxSize pixels; it is never enlarged.
+type Derivative struct {
+	Name    string
+	MaxSize int
+}
+
+// UploadedDerivative is used to save information about a derivative generated for an uploaded image
+type UploadedDerivative struct {
+	Name        string
+	NewFileName string
+	Path        string // slash separated path of the derivative relative to the upload directory
+	FileSize    int64
+	Width       int
+	Height      int
+}
+
+// imageDecoders maps the image types that derivatives can be made from to their decoders.
+// Only the first frame of an animated GIF is used.
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	"image/png":  png.Decode,
+	"image/jpeg": jpeg.Decode,
+	"image/gif":  gif.Decode,
+}
+
+// derivativeFileName returns the name of derivative d of the file named fileName.
+// JPEG derivatives keep the original extension, all others are saved as PNG.
+func derivativeFileName(fileName, contentType string, d Derivative) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+
+	if contentType != "image/jpeg" {
+		ext = ".png"
+	}
+
+	return fmt.Sprintf("%s_%s%s", base, d.Name, ext)
+}
+
+// createDerivatives writes every configured derivative of the stored image name to a temporary
+// file in dir, the directory of the original, and adds it to the pending files of batch
+func (t *Tools) createDerivatives(name, dir string, uploadedFile *UploadedFile, batch *uploadBatch) error {
+	decode, ok := imageDecoders[uploadedFile.ContentType]
+	if !ok || len(t.Derivatives) == 0 {
+		return nil
+	}
+
+	file, err := t.storage().Get(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src, err := decode(file)
+	if err != nil {
+		return fmt.Errorf("file %q could not be decoded: %w", uploadedFile.OriginalFileName, err)
+	}
+
+	for _, d := range t.Derivatives {
+		if d.MaxSize <= 0 {
+			return fmt.Errorf("derivative %q must have a positive MaxSize", d.Name)
+		}
+
+		// derivatives are named like the files beside them, so they must not replace one
+		fileName := derivativeFileName(uploadedFile.NewFileName, uploadedFile.ContentType, d)
+		if isReservedFileName(fileName) {
+			return fmt.Errorf("file name %q is reserved", fileName)
+		}
+		if fileName, err = t.resolveCollision(dir, fileName, batch); err != nil {
+			return err
+		}
+
+		dst := resizeImage(src, d.MaxSize)
+
+		var buf bytes.Buffer
+		if uploadedFile.ContentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: derivativeJPEGQuality})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return err
+		}
+
+		derivative := UploadedDerivative{
+			Name:        d.Name,
+			NewFileName: fileName,
+			Width:       dst.Bounds().Dx(),
+			Height:      dst.Bounds().Dy(),
+		}
+		derivative.Path = path.Join(path.Dir(uploadedFile.Path), derivative.NewFileName)
+
+		tempName := filepath.Join(dir, tempFilePrefix+t.RandomString(16)+tempFileSuffix)
+		derivative.FileSize, err = t.storage().Put(tempName, &buf)
+		if err != nil {
+			_ = t.storage().Delete(tempName)
+			return err
+		}
+
+		batch.pending = append(batch.pending, pendingFile{tempName: tempName, name: filepath.Join(dir, derivative.NewFileName)})
+		uploadedFile.Derivatives = append(uploadedFile.Derivatives, derivative)
+	}
+
+	return nil
+}
+
+// derivativeSize returns the size of an image of w x h pixels scaled to fit within maxSize
+func derivativeSize(w, h, maxSize int) (int, int) {
+	if w <= maxSize && h <= maxSize {
+		return w, h
+	}
+
+	if w >= h {
+		return maxSize, max(1, h*maxSize/w)
+	}
+
+	return max(1, w*maxSize/h), maxSize
+}
+
+// resizeImage scales src down to fit within maxSize using area averaging. The source is converted
+// to RGBA one band of rows at a time, so only the derivative is held in full.
+func resizeImage(src image.Image, maxSize int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := derivativeSize(sw, sh, maxSize)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	if dw == sw && dh == sh {
+		draw.Draw(dst, dst.Bounds(), src, sb.Min, draw.Src)
+		return dst
+	}
+
+	band := image.NewRGBA(image.Rect(0, 0, sw, (sh+dh-1)/dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		draw.Draw(band, image.Rect(0, 0, sw, y1-y0), src, image.Pt(sb.Min.X, sb.Min.Y+y0), draw.Src)
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n uint64
+			for by := 0; by < y1-y0; by++ {
+				i := band.PixOffset(x0, by)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(band.Pix[i])
+					g += uint64(band.Pix[i+1])
+					b += uint64(band.Pix[i+2])
+					a += uint64(band.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,236 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+var derivativeSizeTests = []struct {
+	name           string
+	width, height  int
+	maxSize        int
+	expectedWidth  int
+	expectedHeight int
+}{
+	{name: "landscape", width: 720, height: 504, maxSize: 128, expectedWidth: 128, expectedHeight: 89},
+	{name: "portrait", width: 504, height: 720, maxSize: 128, expectedWidth: 89, expectedHeight: 128},
+	{name: "square", width: 400, height: 400, maxSize: 128, expectedWidth: 128, expectedHeight: 128},
+	{name: "smaller than max", width: 100, height: 50, maxSize: 128, expectedWidth: 100, expectedHeight: 50},
+	{name: "very thin", width: 1000, height: 1, maxSize: 10, expectedWidth: 10, expectedHeight: 1},
+}
+
+func TestDerivativeSize(t *testing.T) {
+	for _, entry := range derivativeSizeTests {
+		w, h := derivativeSize(entry.width, entry.height, entry.maxSize)
+		if w != entry.expectedWidth || h != entry.expectedHeight {
+			t.Errorf("%s: expected %dx%d, got %dx%d", entry.name, entry.expectedWidth, entry.expectedHeight, w, h)
+		}
+	}
+}
+
+var derivativeTests = []struct {
+	name         string
+	fileName     string
+	expectedExts []string
+	expectedDims [][2]int
+}{
+	{name: "png", fileName: "cyborg-ape.png", expectedExts: []string{".png", ".png"}, expectedDims: [][2]int{{128, 128}, {400, 400}}},
+	{name: "jpeg", fileName: "tipfinger.jpg", expectedExts: []string{".jpg", ".jpg"}, expectedDims: [][2]int{{128, 89}, {720, 504}}},
+}
+
+func TestTools_UploadFilesDerivatives(t *testing.T) {
+	for _, entry := range derivativeTests {
+		store := NewMemoryStorage()
+		testTools := Tools{
+			Storage:     store,
+			Derivatives: []Derivative{{Name: "thumb", MaxSize: 128}, {Name: "preview", MaxSize: 1024}},
+		}
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: readTestFile(t, entry.fileName)}), "uploads")
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+
+		if len(uploadedFile.Derivatives) != 2 {
+			t.Fatalf("%s: expected 2 derivatives, got %d", entry.name, len(uploadedFile.Derivatives))
+		}
+
+		base := strings.TrimSuffix(uploadedFile.NewFileName, entry.expectedExts[0])
+		for i, d := range uploadedFile.Derivatives {
+			if d.NewFileName != base+"_"+d.Name+entry.expectedExts[i] {
+				t.Errorf("%s: unexpected derivative name %s", entry.name, d.NewFileName)
+			}
+
+			if d.Width != entry.expectedDims[i][0] || d.Height != entry.expectedDims[i][1] {
+				t.Errorf("%s: expected %s to be %dx%d, got %dx%d", entry.name, d.Name, entry.expectedDims[i][0], entry.expectedDims[i][1], d.Width, d.Height)
+			}
+
+			info, err := store.Stat("uploads/" + d.NewFileName)
+			if err != nil {
+				t.Errorf("%s: expected derivative to be stored: %v", entry.name, err)
+				continue
+			}
+			if info.Size() != d.FileSize {
+				t.Errorf("%s: expected derivative size %d, got %d", entry.name, d.FileSize, info.Size())
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesDerivativesAtomic(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, AtomicUploads: true, Derivatives: []Derivative{{Name: "thumb", MaxSize: 32}}}
+
+	request := newMultipartRequest(t,
+		testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")},
+		testFormFile{field: "file", fileName: "notes.txt", content: []byte("plain text")},
+	)
+
+	if _, err := testTools.UploadFiles(request, "uploads"); err == nil {
+		t.Fatal("expected error for text file")
+	}
+
+	if names, _ := store.List("uploads"); len(names) != 0 {
+		t.Errorf("expected no files to be kept, found %v", names)
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(10, 10, 30, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+
+	dst := resizeImage(src, 4)
+	if dst.Bounds().Dx() != 4 || dst.Bounds().Dy() != 2 {
+		t.Fatalf("unexpected size %v", dst.Bounds())
+	}
+
+	if dst.Pix[0] != 255 || dst.Pix[3] != 255 {
+		t.Errorf("expected white opaque pixels, got %v", dst.Pix[:4])
+	}
+
+	if err := png.Encode(&strings.Builder{}, dst); err != nil {
+		t.Error(err)
+	}
+}
+
+// pngHeader returns a PNG that declares width x height pixels but holds no image data
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8], ihdr[9] = 8, 6 // 8 bit RGBA
+
+	for _, chunk := range []struct {
+		kind string
+		data []byte
+	}{{"IHDR", ihdr}, {"IEND", nil}} {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(chunk.data)))
+		body := append([]byte(chunk.kind), chunk.data...)
+		buf.Write(body)
+		_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(body))
+	}
+
+	return buf.Bytes()
+}
+
+func TestTools_UploadFilesDerivativesPixelLimit(t *testing.T) {
+	var tests = []struct {
+		name          string
+		tools         Tools
+		expectedLimit int64
+	}{
+		{name: "default", tools: Tools{Derivatives: []Derivative{{Name: "thumb", MaxSize: 32}}}, expectedLimit: defaultMaxDerivativePixels},
+		{name: "configured", tools: Tools{Derivatives: []Derivative{{Name: "thumb", MaxSize: 32}}, MaxImagePixels: 1000}, expectedLimit: 1000},
+	}
+
+	for _, e := range tests {
+		request := newMultipartRequest(t, testFormFile{"file", "bomb.png", pngHeader(30000, 30000)})
+		_, err := e.tools.UploadFiles(request, t.TempDir())
+
+		var limitErr *UploadLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != LimitImagePixels || limitErr.Max != e.expectedLimit {
+			t.Errorf("%s: expected a pixel limit of %d, got %v", e.name, e.expectedLimit, err)
+		}
+	}
+}
+
+func TestResizeImageBands(t *testing.T) {
+	// left half black, right half white, from a source not at the origin
+	src := image.NewGray(image.Rect(5, 5, 11, 14))
+	for y := 5; y < 14; y++ {
+		for x := 8; x < 11; x++ {
+			src.Pix[src.PixOffset(x, y)] = 255
+		}
+	}
+
+	dst := resizeImage(src, 3)
+	if dst.Bounds().Dx() != 2 || dst.Bounds().Dy() != 3 {
+		t.Fatalf("unexpected size %v", dst.Bounds())
+	}
+
+	for y := 0; y < 3; y++ {
+		if left, right := dst.RGBAAt(0, y).R, dst.RGBAAt(1, y).R; left != 0 || right != 255 {
+			t.Errorf("row %d: expected black and white, got %d and %d", y, left, right)
+		}
+	}
+}
+
+var derivativeCollisionTests = []struct {
+	name          string
+	collision     CollisionStrategy
+	expectedName  string
+	errorExpected bool
+}{
+	{name: "reject", collision: CollisionReject, errorExpected: true},
+	{name: "append counter", collision: CollisionAppendCounter, expectedName: "photo_thumb-1.png"},
+}
+
+func TestTools_UploadFilesDerivativeCollision(t *testing.T) {
+	for _, entry := range derivativeCollisionTests {
+		store := NewMemoryStorage()
+		if _, err := store.Put("uploads/photo_thumb.png", strings.NewReader("existing")); err != nil {
+			t.Fatal(err)
+		}
+
+		testTools := Tools{Storage: store, FileNameCollision: entry.collision, Derivatives: []Derivative{{Name: "thumb", MaxSize: 32}}}
+
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: "photo.png", content: readTestFile(t, "cyborg-ape.png")})
+		uploadedFile, err := testTools.UploadFile(request, "uploads", false)
+
+		if entry.errorExpected {
+			var existsErr *FileExistsError
+			if !errors.As(err, &existsErr) {
+				t.Errorf("%s: expected FileExistsError, got %v", entry.name, err)
+			}
+			if _, err := store.Stat("uploads/photo.png"); err == nil {
+				t.Errorf("%s: expected the original to be rejected too", entry.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+		} else if len(uploadedFile.Derivatives) != 1 || uploadedFile.Derivatives[0].NewFileName != entry.expectedName {
+			t.Errorf("%s: expected derivative %s, got %+v", entry.name, entry.expectedName, uploadedFile.Derivatives)
+		}
+
+		file, err := store.Get("uploads/photo_thumb.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		existing, _ := io.ReadAll(file)
+		file.Close()
+		if string(existing) != "existing" {
+			t.Errorf("%s: existing file was overwritten", entry.name)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// magicSignature identifies a file type by the bytes found at a fixed offset
+type magicSignature struct {
+	offset      int
+	magic       []byte
+	contentType string
+}
+
+// magicSignatures are checked, in order, before falling back to http.DetectContentType
+var magicSignatures = []magicSignature{
+	{offset: 0, magic: []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), contentType: "application/x-ole-storage"},
+	{offset: 0, magic: []byte("II*\x00"), contentType: "image/tiff"},
+	{offset: 0, magic: []byte("MM\x00*"), contentType: "image/tiff"},
+	{offset: 0, magic: []byte("fLaC"), contentType: "audio/flac"},
+	{offset: 0, magic: []byte("{\\rtf"), contentType: "application/rtf"},
+	{offset: 0, magic: []byte("BZh"), contentType: "application/x-bzip2"},
+	{offset: 0, magic: []byte("\xFD7zXZ\x00"), contentType: "application/x-xz"},
+	{offset: 0, magic: []byte("7z\xBC\xAF\x27\x1C"), contentType: "application/x-7z-compressed"},
+	{offset: 257, magic: []byte("ustar"), contentType: "application/x-tar"},
+}
+
+// ftypBrands maps the major brand of an ISO base media file (the "ftyp" box) to its type
+var ftypBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"hevc": "image/heic",
+	"hevx": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"qt  ": "video/quicktime",
+	"M4A ": "audio/mp4",
+	"M4V ": "video/mp4",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"iso5": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+}
+
+// oleTypes maps extensions to the legacy Office type of an OLE compound file, which cannot be
+// told apart from the first bytes alone
+var oleTypes = map[string]string{
+	".doc": "application/msword",
+	".dot": "application/msword",
+	".xls": "application/vnd.ms-excel",
+	".xlt": "application/vnd.ms-excel",
+	".ppt": "application/vnd.ms-powerpoint",
+	".pps": "application/vnd.ms-powerpoint",
+	".msg": "application/vnd.ms-outlook",
+}
+
+// zipMarkers map a file found inside a ZIP archive to the type of document it identifies,
+// in order of precedence
+var zipMarkers = []struct {
+	name        string
+	contentType string
+}{
+	{name: "word/document.xml", contentType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{name: "xl/workbook.xml", contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{name: "ppt/presentation.xml", contentType: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{name: "AndroidManifest.xml", contentType: "application/vnd.android.package-archive"},
+	{name: "META-INF/MANIFEST.MF", contentType: "application/java-archive"},
+}
+
+// zipMimetypes are the types that OpenDocument and EPUB files declare in their "mimetype" entry
+var zipMimetypes = map[string]bool{
+	"application/vnd.oasis.opendocument.text":         true,
+	"application/vnd.oasis.opendocument.spreadsheet":  true,
+	"application/vnd.oasis.opendocument.presentation": true,
+	"application/vnd.oasis.opendocument.graphics":     true,
+	"application/epub+zip":                            true,
+}
+
+// fileTypeExtensions lists the extensions accepted for each type when RequireMatchingExtension is set.
+// Types not listed here fall back to the system MIME table.
+var fileTypeExtensions = map[string][]string{
+	"image/jpeg":                    {".jpg", ".jpeg", ".jpe", ".jfif"},
+	"image/png":                     {".png"},
+	"image/gif":                     {".gif"},
+	"image/webp":                    {".webp"},
+	"image/heic":                    {".heic"},
+	"image/heif":                    {".heif", ".heic"},
+	"image/avif":                    {".avif"},
+	"image/svg+xml":                 {".svg"},
+	"image/tiff":                    {".tif", ".tiff"},
+	"image/bmp":                     {".bmp"},
+	"image/x-icon":                  {".ico"},
+	"application/pdf":               {".pdf"},
+	"application/zip":               {".zip"},
+	"application/x-gzip":            {".gz", ".tgz"},
+	"application/x-tar":             {".tar"},
+	"application/x-bzip2":           {".bz2", ".tbz2"},
+	"application/x-xz":              {".xz", ".txz"},
+	"application/x-7z-compressed":   {".7z"},
+	"application/x-rar-compressed":  {".rar"},
+	"application/rtf":               {".rtf"},
+	"application/msword":            {".doc", ".dot"},
+	"application/vnd.ms-excel":      {".xls", ".xlt"},
+	"application/vnd.ms-powerpoint": {".ppt", ".pps"},
+	"application/vnd.ms-outlook":    {".msg"},
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   {".docx", ".docm"},
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         {".xlsx", ".xlsm"},
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": {".pptx", ".pptm"},
+	"application/vnd.oasis.opendocument.text":                                   {".odt"},
+	"application/vnd.oasis.opendocument.spreadsheet":                            {".ods"},
+	"application/vnd.oasis.opendocument.presentation":                           {".odp"},
+	"application/epub+zip":                    {".epub"},
+	"application/java-archive":                {".jar"},
+	"application/vnd.android.package-archive": {".apk"},
+	"video/mp4":       {".mp4", ".m4v"},
+	"audio/mp4":       {".m4a"},
+	"video/quicktime": {".mov", ".qt"},
+	"video/3gpp":      {".3gp"},
+	"video/webm":      {".webm", ".mkv"},
+	"audio/mpeg":      {".mp3"},
+	"audio/flac":      {".flac"},
+	"audio/wave":      {".wav"},
+	"application/ogg": {".ogg", ".oga", ".ogv"},
+	"font/woff":       {".woff"},
+	"font/woff2":      {".woff2"},
+	"text/plain":      {".txt", ".text", ".csv", ".log", ".md"},
+	"text/html":       {".html", ".htm"},
+	"text/xml":        {".xml"},
+}
+
+// FileTypeError is returned when an uploaded file's detected type is not permitted, or when
+// RequireMatchingExtension is set and the file name's extension does not match the detected type
+type FileTypeError struct {
+	FileName    string
+	ContentType string
+	Extension   string
+}
+
+// Error implements the error interface
+func (e *FileTypeError) Error() string {
+	if e.Extension != "" {
+		return fmt.Sprintf("file %q has extension %q, which does not match its detected type %s", e.FileName, e.Extension, e.ContentType)
+	}
+
+	return fmt.Sprintf("file %q has type %s, which is not permitted", e.FileName, e.ContentType)
+}
+
+// DetectFileType returns the MIME type of data, which should hold at least the first 512 bytes
+// of a file. It recognises more formats than http.DetectContentType, which it falls back to.
+func (t *Tools) DetectFileType(data []byte) string {
+	for _, sig := range magicSignatures {
+		if len(data) >= sig.offset+len(sig.magic) && bytes.Equal(data[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.contentType
+		}
+	}
+
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		if contentType, ok := ftypBrands[string(data[8:12])]; ok {
+			return contentType
+		}
+	}
+
+	if isSVG(data) {
+		return "image/svg+xml"
+	}
+
+	return http.DetectContentType(data)
+}
+
+// isSVG reports whether data looks like the start of an SVG document: markup whose first
+// element, after any XML declaration, comments or doctype, is <svg
+func isSVG(data []byte) bool {
+	s := bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	for {
+		s = bytes.TrimLeft(s, " \t\r\n")
+		switch {
+		case bytes.HasPrefix(s, []byte("<?")):
+			end := bytes.Index(s, []byte("?>"))
+			if end < 0 {
+				return false
+			}
+			s = s[end+2:]
+		case bytes.HasPrefix(s, []byte("<!--")):
+			end := bytes.Index(s, []byte("-->"))
+			if end < 0 {
+				return false
+			}
+			s = s[end+3:]
+		case bytes.HasPrefix(s, []byte("<!")):
+			end := bytes.IndexByte(s, '>')
+			if end < 0 {
+				return false
+			}
+			s = s[end+1:]
+		default:
+			return len(s) > 4 && bytes.EqualFold(s[:4], []byte("<svg")) && strings.ContainsRune(" \t\r\n>/", rune(s[4]))
+		}
+	}
+}
+
+// mediaType returns contentType without any parameters, in lower case
+func mediaType(contentType string) string {
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		return parsed
+	}
+
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// matchFileType reports whether contentType matches pattern, which may be a full type such as
+// "image/png" or a wildcard such as "image/*" or "*/*"
+func matchFileType(pattern, contentType string) bool {
+	if strings.EqualFold(pattern, contentType) {
+		return true
+	}
+
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	contentType = mediaType(contentType)
+
+	if pattern == "*/*" || pattern == "*" {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+
+	return pattern == contentType
+}
+
+// matchAnyFileType reports whether contentType matches any of the allowed types
+func matchAnyFileType(allowedTypes []string, contentType string) bool {
+	for _, allowed := range allowedTypes {
+		if matchFileType(allowed, contentType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isZipType reports whether contentType is a ZIP archive, or a document format stored in one
+func isZipType(contentType string) bool {
+	contentType = mediaType(contentType)
+	if contentType == "application/zip" || zipMimetypes[contentType] {
+		return true
+	}
+
+	for _, marker := range zipMarkers {
+		if marker.contentType == contentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsZipType reports whether any allowed type could match a ZIP based document, so that a
+// sniffed ZIP archive is kept until its contents can be examined
+func (t *Tools) allowsZipType(field string) bool {
+	if t.policyFileTypes != nil && !anyZipType(t.policyFileTypes) {
+		return false
+	}
+
+	return anyZipType(t.allowedFileTypes(field))
+}
+
+// anyZipType reports whether any of the allowed types could match a ZIP based document
+func anyZipType(allowedTypes []string) bool {
+	for _, allowed := range allowedTypes {
+		if isZipType(allowed) || matchFileType(allowed, "application/zip") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refineFileType replaces types that cannot be told apart from the first bytes alone with a more
+// specific type, using the extension of fileName
+func refineFileType(fileName, contentType string) string {
+	if contentType == "application/x-ole-storage" {
+		if refined, ok := oleTypes[strings.ToLower(filepath.Ext(fileName))]; ok {
+			return refined
+		}
+	}
+
+	return contentType
+}
+
+// checkSniffedType checks the type detected from the first bytes of a file. ZIP archives are let
+// through for now if a ZIP based type is allowed, and are checked by inspectZipType once the
+// whole file is stored.
+func (t *Tools) checkSniffedType(field, fileName, contentType string) error {
+	if mediaType(contentType) == "application/zip" && t.allowsZipType(field) {
+		return nil
+	}
+
+	return t.checkFileType(field, fileName, contentType)
+}
+
+// checkFileType checks a detected type against the types allowed for field and, if
+// RequireMatchingExtension is set, against the extension of fileName
+func (t *Tools) checkFileType(field, fileName, contentType string) error {
+	if !t.allowedFileType(field, contentType) {
+		return &FileTypeError{FileName: fileName, ContentType: contentType}
+	}
+
+	return t.checkExtension(fileName, contentType)
+}
+
+// checkExtension checks that the extension of fileName is one used for contentType
+func (t *Tools) checkExtension(fileName, contentType string) error {
+	if !t.RequireMatchingExtension {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	extensions, ok := fileTypeExtensions[mediaType(contentType)]
+	if !ok {
+		extensions, _ = mime.ExtensionsByType(mediaType(contentType))
+	}
+
+	for _, allowed := range extensions {
+		if ext == allowed {
+			return nil
+		}
+	}
+
+	if ext == "" {
+		ext = "(none)"
+	}
+
+	return &FileTypeError{FileName: fileName, ContentType: contentType, Extension: ext}
+}
+
+// inspectZipType identifies the document format of the stored ZIP archive name from its
+// contents, then checks the refined type
+func (t *Tools) inspectZipType(name string, uploadedFile *UploadedFile) error {
+	file, err := t.storage().Get(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, size, cleanup, err := readerAt(file, file, uploadedFile.FileSize)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("file %q is not a valid zip archive: %w", uploadedFile.OriginalFileName, err)
+	}
+	uploadedFile.ContentType = zipDocumentType(archive)
+
+	return t.checkFileType(uploadedFile.FieldName, uploadedFile.OriginalFileName, uploadedFile.ContentType)
+}
+
+// zipDocumentType returns the document type stored in archive, or application/zip for a plain archive
+func zipDocumentType(archive *zip.Reader) string {
+	names := make(map[string]bool, len(archive.File))
+
+	for _, f := range archive.File {
+		names[f.Name] = true
+
+		if f.Name != "mimetype" || f.UncompressedSize64 > 100 {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		declared, _ := io.ReadAll(rc)
+		_ = rc.Close()
+
+		if contentType := strings.TrimSpace(string(declared)); zipMimetypes[contentType] {
+			return contentType
+		}
+	}
+
+	for _, marker := range zipMarkers {
+		if names[marker.name] {
+			return marker.contentType
+		}
+	}
+
+	return "application/zip"
+}
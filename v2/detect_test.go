@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testZip returns a ZIP archive holding the named files
+func testZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var detectFileTypeTests = []struct {
+	name     string
+	data     string
+	expected string
+}{
+	{name: "png", data: "\x89PNG\r\n\x1a\n", expected: "image/png"},
+	{name: "webp", data: "RIFF\x00\x00\x00\x00WEBPVP8 ", expected: "image/webp"},
+	{name: "heic", data: "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", expected: "image/heic"},
+	{name: "avif", data: "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00", expected: "image/avif"},
+	{name: "mp4", data: "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00", expected: "video/mp4"},
+	{name: "quicktime", data: "\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00", expected: "video/quicktime"},
+	{name: "svg", data: `<svg xmlns="http://www.w3.org/2000/svg"></svg>`, expected: "image/svg+xml"},
+	{name: "svg with prolog", data: "\xEF\xBB\xBF<?xml version=\"1.0\"?>\n<!-- drawn by hand -->\n<!DOCTYPE svg>\n<svg>", expected: "image/svg+xml"},
+	{name: "svg lookalike", data: `<svgfoo></svgfoo>`, expected: "text/plain; charset=utf-8"},
+	{name: "ole", data: "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", expected: "application/x-ole-storage"},
+	{name: "tiff", data: "II*\x00\x08\x00", expected: "image/tiff"},
+	{name: "rtf", data: `{\rtf1\ansi`, expected: "application/rtf"},
+	{name: "text", data: "hello", expected: "text/plain; charset=utf-8"},
+}
+
+func TestTools_DetectFileType(t *testing.T) {
+	var tools Tools
+
+	for _, entry := range detectFileTypeTests {
+		actual := tools.DetectFileType([]byte(entry.data))
+		if actual != entry.expected {
+			t.Errorf("%s: expected %s, got %s", entry.name, entry.expected, actual)
+		}
+	}
+}
+
+var matchFileTypeTests = []struct {
+	pattern     string
+	contentType string
+	expected    bool
+}{
+	{pattern: "image/png", contentType: "image/png", expected: true},
+	{pattern: "IMAGE/PNG", contentType: "image/png", expected: true},
+	{pattern: "image/*", contentType: "image/webp", expected: true},
+	{pattern: "image/*", contentType: "application/pdf", expected: false},
+	{pattern: "text/*", contentType: "text/plain; charset=utf-8", expected: true},
+	{pattern: "text/plain", contentType: "text/plain; charset=utf-8", expected: true},
+	{pattern: "*/*", contentType: "video/mp4", expected: true},
+	{pattern: "image/*", contentType: "imagex/png", expected: false},
+}
+
+func TestMatchFileType(t *testing.T) {
+	for _, entry := range matchFileTypeTests {
+		if actual := matchFileType(entry.pattern, entry.contentType); actual != entry.expected {
+			t.Errorf("%s against %s: expected %t, got %t", entry.pattern, entry.contentType, entry.expected, actual)
+		}
+	}
+}
+
+// streamStorage returns files that cannot be read at random, as object storage backends do
+type streamStorage struct {
+	Storage
+}
+
+// Get implements Storage
+func (s streamStorage) Get(name string) (io.ReadCloser, error) {
+	file, err := s.Storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct{ io.ReadCloser }{file}, nil
+}
+
+func TestTools_UploadFilesDetection(t *testing.T) {
+	pngData := readTestFile(t, "cyborg-ape.png")
+	docx := testZip(t, map[string]string{"[Content_Types].xml": "<Types/>", "word/document.xml": "<w:document/>"})
+	odt := testZip(t, map[string]string{"mimetype": "application/vnd.oasis.opendocument.text", "content.xml": "<office/>"})
+	plainZip := testZip(t, map[string]string{"readme.txt": "hello"})
+
+	var uploadDetectionTests = []struct {
+		name              string
+		fileName          string
+		content           []byte
+		allowedTypes      []string
+		matchExtension    bool
+		expectedType      string
+		expectedExtension string
+		errorExpected     bool
+	}{
+		{name: "png named html allowed", fileName: "evil.html", content: pngData, allowedTypes: []string{"image/*"}, expectedType: "image/png"},
+		{name: "png named html rejected", fileName: "evil.html", content: pngData, allowedTypes: []string{"image/*"}, matchExtension: true, expectedExtension: ".html", errorExpected: true},
+		{name: "png without extension rejected", fileName: "ape", content: pngData, allowedTypes: []string{"image/*"}, matchExtension: true, expectedExtension: "(none)", errorExpected: true},
+		{name: "png matching extension", fileName: "ape.PNG", content: pngData, allowedTypes: []string{"image/*"}, matchExtension: true, expectedType: "image/png"},
+		{name: "docx", fileName: "report.docx", content: docx, allowedTypes: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}, matchExtension: true, expectedType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{name: "docx named zip", fileName: "report.zip", content: docx, allowedTypes: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}, matchExtension: true, expectedExtension: ".zip", errorExpected: true},
+		{name: "odt", fileName: "letter.odt", content: odt, allowedTypes: []string{"application/vnd.oasis.opendocument.text"}, matchExtension: true, expectedType: "application/vnd.oasis.opendocument.text"},
+		{name: "plain zip as docx", fileName: "report.docx", content: plainZip, allowedTypes: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"}, errorExpected: true},
+		{name: "plain zip", fileName: "bundle.zip", content: plainZip, allowedTypes: []string{"application/zip"}, matchExtension: true, expectedType: "application/zip"},
+		{name: "legacy word", fileName: "old.doc", content: []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1 word"), allowedTypes: []string{"application/msword"}, matchExtension: true, expectedType: "application/msword"},
+	}
+
+	for _, entry := range uploadDetectionTests {
+		for _, store := range []Storage{NewMemoryStorage(), streamStorage{NewMemoryStorage()}} {
+			testTools := Tools{Storage: store, AllowedFileTypes: entry.allowedTypes, RequireMatchingExtension: entry.matchExtension}
+
+			uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: entry.content}), "uploads", false)
+
+			if entry.errorExpected {
+				var typeErr *FileTypeError
+				if !errors.As(err, &typeErr) {
+					t.Errorf("%s: expected FileTypeError, got %v", entry.name, err)
+				} else if typeErr.Extension != entry.expectedExtension {
+					t.Errorf("%s: expected extension %q in error, got %q", entry.name, entry.expectedExtension, typeErr.Extension)
+				}
+
+				if names, _ := store.List("uploads"); len(names) != 0 {
+					t.Errorf("%s: expected rejected file to be removed, found %v", entry.name, names)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+				continue
+			}
+
+			if uploadedFile.ContentType != entry.expectedType {
+				t.Errorf("%s: expected type %s, got %s", entry.name, entry.expectedType, uploadedFile.ContentType)
+			}
+		}
+	}
+}
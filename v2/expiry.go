@@ -0,0 +1,310 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expirySuffix is appended to the name of a temporary file to get the name of its expiry record
+const expirySuffix = ".expires.json"
+
+// expiryIndexDir is the directory below an upload directory that indexes its temporary files by
+// expiry time. Uploaded names never start with a dot, so it cannot clash with an upload.
+const expiryIndexDir = ".expiring"
+
+// defaultJanitorInterval is the time between sweeps when Janitor.Interval is not set
+const defaultJanitorInterval = time.Minute
+
+// errFileExpired is returned by ClaimFile for files past their expiry time
+var errFileExpired = errors.New("file has expired")
+
+// fileExpiry is the record written next to a temporary file. Derivatives are the names of the
+// derivatives of the file, which live in the same directory, and Index is the name of its entry
+// in the expiry index of the upload directory.
+type fileExpiry struct {
+	Expires     time.Time `json:"expires"`
+	Derivatives []string  `json:"derivatives,omitempty"`
+	Index       string    `json:"index"`
+}
+
+// expiryIndexEntry is an entry of the expiry index. Entries are named after the expiry time, so
+// that listing the index returns them in the order they fall due.
+type expiryIndexEntry struct {
+	Name string `json:"name"`
+}
+
+// UploadFilesWithTTL uploads files like UploadFiles, but as temporary files that RemoveExpired
+// and Janitor, given the same uploadDir, delete along with their derivatives and metadata once
+// ttl has passed. Files
+// passed to ClaimFile before then are kept. With ContentAddressed set, a file that duplicates
+// one already stored is kept or removed with the stored file, and a duplicate uploaded without
+// a TTL claims it.
+func (t *Tools) UploadFilesWithTTL(r *http.Request, uploadDir string, ttl time.Duration, rename ...bool) ([]*UploadedFile, error) {
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+
+	renameFile := true
+
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return t.UploadFilesWithOptions(r, uploadDir, UploadOptions{KeepFileName: !renameFile, TTL: ttl})
+}
+
+// ClaimFile keeps the temporary file stored as name, i.e. the upload directory joined with
+// UploadedFile.Path, so that it is no longer removed when it expires. Claiming a file that was
+// not uploaded with a TTL does nothing; claiming one that has expired is an error.
+func (t *Tools) ClaimFile(name string) error {
+	expiry, err := t.loadExpiry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, err = t.storage().Stat(name)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if !time.Now().Before(expiry.Expires) {
+		return errFileExpired
+	}
+
+	return t.deleteExpiry(name, expiry)
+}
+
+// RemoveExpired removes every temporary file uploaded to the upload directory dir whose TTL has
+// passed, with its derivatives and its metadata, and returns the number of files removed. Only
+// the index of dir is listed, and only the records of expired files are read. Files that cannot
+// be removed are left for the next call and their errors are returned joined together.
+func (t *Tools) RemoveExpired(dir string) (int, error) {
+	entries, err := t.storage().List(filepath.Join(dir, expiryIndexDir))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(entries)
+
+	now := time.Now()
+	removed := 0
+	var errs []error
+	for _, entry := range entries {
+		expires, ok := expiryIndexTime(entry)
+		if !ok {
+			continue
+		}
+		if now.Before(expires) {
+			break
+		}
+
+		ok, err := t.removeIndexedFile(entry, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			removed++
+		}
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// removeIndexedFile removes the file of a due index entry, if it has expired, and the entry. It
+// reports whether the file was removed.
+func (t *Tools) removeIndexedFile(entry string, now time.Time) (bool, error) {
+	file, err := t.storage().Get(entry)
+	if err != nil {
+		return false, err
+	}
+	data, err := io.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		return false, err
+	}
+
+	var indexed expiryIndexEntry
+	if err := json.Unmarshal(data, &indexed); err != nil {
+		return false, err
+	}
+
+	// the file may have been claimed, or its record indexed again, since the entry was written
+	expiry, err := t.loadExpiry(indexed.Name)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && expiry.Index != entry) {
+		return false, t.storage().Delete(entry)
+	}
+	if err != nil {
+		return false, err
+	}
+	if now.Before(expiry.Expires) {
+		return false, nil
+	}
+
+	return true, t.removeExpired(indexed.Name, expiry)
+}
+
+// expiryIndexTime returns the expiry time an index entry is named after
+func expiryIndexTime(entry string) (time.Time, bool) {
+	prefix, _, _ := strings.Cut(filepath.Base(entry), "-")
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
+
+// removeExpired deletes the file name, its derivatives and metadata, and then its expiry record,
+// so that a failed removal is retried by the next sweep
+func (t *Tools) removeExpired(name string, expiry fileExpiry) error {
+	names := []string{name}
+	for _, derivative := range expiry.Derivatives {
+		names = append(names, filepath.Join(filepath.Dir(name), derivative))
+	}
+
+	for _, n := range names {
+		if err := t.storage().Delete(n); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	if t.Metadata != nil {
+		if err := t.Metadata.Delete(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return t.deleteExpiry(name, expiry)
+}
+
+// newFileExpiry returns the expiry record of a file uploaded to uploadDir with ttl
+func (t *Tools) newFileExpiry(uploadDir string, uploadedFile *UploadedFile, ttl time.Duration) *fileExpiry {
+	expiry := fileExpiry{Expires: time.Now().Add(ttl).UTC()}
+	expiry.Index = t.expiryIndexName(filepath.Join(uploadDir, expiryIndexDir), expiry.Expires)
+	for _, derivative := range uploadedFile.Derivatives {
+		expiry.Derivatives = append(expiry.Derivatives, derivative.NewFileName)
+	}
+
+	return &expiry
+}
+
+// expiryIndexName returns a new name for an entry of the expiry index indexDir. The zero padded
+// expiry time sorts the entries, and the random part keeps files expiring together apart.
+func (t *Tools) expiryIndexName(indexDir string, expires time.Time) string {
+	return filepath.Join(indexDir, fmt.Sprintf("%020d-%s.json", expires.UnixNano(), t.RandomString(10)))
+}
+
+// saveExpiry writes the expiry record of the file name and its index entry
+func (t *Tools) saveExpiry(name string, expiry fileExpiry) error {
+	data, err := json.Marshal(expiry)
+	if err != nil {
+		return err
+	}
+	if _, err := t.storage().Put(name+expirySuffix, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(expiryIndexEntry{Name: name})
+	if err != nil {
+		return err
+	}
+	if _, err := t.storage().Put(expiry.Index, bytes.NewReader(entry)); err != nil {
+		_ = t.storage().Delete(name + expirySuffix)
+		return err
+	}
+
+	return nil
+}
+
+// deleteExpiry removes the expiry record of the file name and then its index entry, which is
+// dropped by the next sweep if it cannot be removed here
+func (t *Tools) deleteExpiry(name string, expiry fileExpiry) error {
+	if err := t.storage().Delete(name + expirySuffix); err != nil {
+		return err
+	}
+	if err := t.storage().Delete(expiry.Index); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// loadExpiry reads the expiry record of the file name
+func (t *Tools) loadExpiry(name string) (fileExpiry, error) {
+	var expiry fileExpiry
+
+	file, err := t.storage().Get(name + expirySuffix)
+	if err != nil {
+		return expiry, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return expiry, err
+	}
+
+	err = json.Unmarshal(data, &expiry)
+
+	return expiry, err
+}
+
+// Janitor periodically removes the expired temporary files of the upload directory Dir, as
+// RemoveExpired does
+type Janitor struct {
+	Tools    *Tools
+	Dir      string
+	Interval time.Duration   // time between sweeps, one minute if not set
+	OnError  func(err error) // called with the error of a failed sweep, which is otherwise ignored
+}
+
+// NewJanitor returns a Janitor for the upload directory dir
+func (t *Tools) NewJanitor(dir string) *Janitor {
+	return &Janitor{Tools: t, Dir: dir}
+}
+
+// Start sweeps Dir in a new goroutine, at once and then every Interval, until ctx is done. The
+// returned channel is closed once the janitor has stopped.
+func (j *Janitor) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		j.Run(ctx)
+	}()
+
+	return done
+}
+
+// Run sweeps Dir at once and then every Interval, returning when ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := j.Tools.RemoveExpired(j.Dir); err != nil && j.OnError != nil {
+			j.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
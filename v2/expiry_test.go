@@ -0,0 +1,240 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expireFile moves the expiry of the temporary file name into the past, indexing it again
+func expireFile(t *testing.T, tools *Tools, name string) {
+	t.Helper()
+
+	expiry, err := tools.loadExpiry(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tools.storage().Delete(expiry.Index); err != nil {
+		t.Fatal(err)
+	}
+	expiry.Expires = time.Now().Add(-time.Second)
+	expiry.Index = tools.expiryIndexName(path.Dir(expiry.Index), expiry.Expires)
+	if err := tools.saveExpiry(name, expiry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTools_UploadFilesWithTTL(t *testing.T) {
+	var tests = []struct {
+		name         string
+		claim        bool
+		expire       bool
+		expectedKept bool
+	}{
+		{name: "unclaimed", expire: true, expectedKept: false},
+		{name: "claimed", claim: true, expire: true, expectedKept: true},
+		{name: "not expired", expectedKept: true},
+	}
+
+	for _, e := range tests {
+		store := NewMemoryStorage()
+		testTools := Tools{
+			Storage:     store,
+			Derivatives: []Derivative{{Name: "thumb", MaxSize: 32}},
+			Metadata:    &SidecarStore{Storage: store},
+		}
+
+		request := newMultipartRequest(t, testFormFile{"file", "ape.png", readTestFile(t, "cyborg-ape.png")})
+		uploadedFiles, err := testTools.UploadFilesWithTTL(request, "uploads", time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		uploadedFile := uploadedFiles[0]
+		name := path.Join("uploads", uploadedFile.Path)
+		names := []string{
+			name,
+			path.Join("uploads", uploadedFile.Derivatives[0].Path),
+			name + sidecarSuffix,
+		}
+
+		if e.claim {
+			if err := testTools.ClaimFile(name); err != nil {
+				t.Errorf("%s: unexpected claim error %v", e.name, err)
+			}
+		}
+		if e.expire && !e.claim {
+			expireFile(t, &testTools, name)
+		}
+
+		removed, err := testTools.RemoveExpired("uploads")
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if e.expectedKept == (removed != 0) {
+			t.Errorf("%s: unexpected number of files removed %d", e.name, removed)
+		}
+
+		for _, n := range names {
+			if _, err := store.Stat(n); (err == nil) != e.expectedKept {
+				t.Errorf("%s: expected %s to be kept: %t, got error %v", e.name, n, e.expectedKept, err)
+			}
+		}
+
+		if _, err := store.Stat(name + expirySuffix); err == nil && (e.claim || e.expire) {
+			t.Errorf("%s: expected the expiry record to be removed", e.name)
+		}
+	}
+}
+
+func TestTools_ClaimFile(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+
+	request := newMultipartRequest(t,
+		testFormFile{"file", "a.pdf", pdfBytes},
+		testFormFile{"file", "b.pdf", pdfBytes},
+	)
+	if _, err := testTools.UploadFilesWithTTL(request, "uploads", time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTools.UploadFiles(newMultipartRequest(t, testFormFile{"file", "c.pdf", pdfBytes}), "uploads", false); err != nil {
+		t.Fatal(err)
+	}
+	expireFile(t, &testTools, "uploads/b.pdf")
+
+	var tests = []struct {
+		name        string
+		file        string
+		expectError bool
+	}{
+		{name: "temporary", file: "uploads/a.pdf"},
+		{name: "claimed twice", file: "uploads/a.pdf"},
+		{name: "expired", file: "uploads/b.pdf", expectError: true},
+		{name: "permanent", file: "uploads/c.pdf"},
+		{name: "missing", file: "uploads/d.pdf", expectError: true},
+	}
+
+	for _, e := range tests {
+		err := testTools.ClaimFile(e.file)
+		if (err != nil) != e.expectError {
+			t.Errorf("%s: expected error %t, got %v", e.name, e.expectError, err)
+		}
+	}
+
+	if _, err := testTools.UploadFilesWithTTL(request, "uploads", 0); err == nil {
+		t.Error("expected an error for a zero ttl")
+	}
+}
+
+func TestJanitor_Start(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+
+	request := newMultipartRequest(t, testFormFile{"file", "a.pdf", pdfBytes})
+	if _, err := testTools.UploadFilesWithTTL(request, "uploads", time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := testTools.NewJanitor("uploads")
+	janitor.Interval = 5 * time.Millisecond
+	janitor.OnError = func(err error) {
+		t.Errorf("unexpected janitor error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := janitor.Start(ctx)
+
+	expireFile(t, &testTools, "uploads/a.pdf")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := store.Stat("uploads/a.pdf"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the janitor to remove the expired file")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected the janitor to stop once the context is done")
+	}
+
+	if names, _ := store.List("uploads"); len(names) != 0 {
+		t.Errorf("expected no files to be left, got %v", names)
+	}
+
+	if !errors.Is(testTools.ClaimFile("uploads/a.pdf"), fs.ErrNotExist) {
+		t.Error("expected a removed file not to be claimable")
+	}
+}
+
+func TestTools_UploadFilesWithTTLDuplicate(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, ContentAddressed: true}
+
+	temporary, err := testTools.UploadFilesWithTTL(newMultipartRequest(t, testFormFile{"file", "a.pdf", pdfBytes}), "uploads", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := path.Join("uploads", temporary[0].Path)
+	expireFile(t, &testTools, name)
+
+	permanent, err := testTools.UploadFiles(newMultipartRequest(t, testFormFile{"file", "b.pdf", pdfBytes}), "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permanent[0].Duplicate || permanent[0].Path != temporary[0].Path {
+		t.Fatalf("expected a duplicate of %s, got %+v", temporary[0].Path, permanent[0])
+	}
+
+	removed, err := testTools.RemoveExpired("uploads")
+	if err != nil || removed != 0 {
+		t.Errorf("expected nothing to be removed, got %d and %v", removed, err)
+	}
+	if _, err := store.Stat(path.Join("uploads", permanent[0].Path)); err != nil {
+		t.Errorf("expected the claimed file to be kept, got %v", err)
+	}
+}
+
+func TestTools_RemoveExpiredIndex(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+
+	request := newMultipartRequest(t,
+		testFormFile{"file", "a.pdf", pdfBytes},
+		testFormFile{"file", "b.pdf", pdfBytes},
+	)
+	if _, err := testTools.UploadFilesWithTTL(request, "uploads", time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	expireFile(t, &testTools, "uploads/a.pdf")
+
+	// records of files that are not due are never read
+	if _, err := store.Put("uploads/b.pdf"+expirySuffix, strings.NewReader("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := testTools.RemoveExpired("uploads")
+	if err != nil || removed != 1 {
+		t.Errorf("expected 1 file to be removed, got %d and %v", removed, err)
+	}
+
+	entries, _ := store.List("uploads/" + expiryIndexDir)
+	if len(entries) != 1 {
+		t.Errorf("expected the entry of b.pdf to be left, got %v", entries)
+	}
+
+	if _, err := store.Stat("uploads/b.pdf"); err != nil {
+		t.Errorf("expected b.pdf to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// FieldRule restricts the files accepted from one form field. Zero values fall back to the
+// matching Tools setting, e.g. an empty AllowedFileTypes uses Tools.AllowedFileTypes.
+type FieldRule struct {
+	AllowedFileTypes []string
+	MaxFileSize      int64
+	MaxFileCount     int
+}
+
+// UnexpectedFieldError is returned when Tools.FieldRules is set and a file is sent in a form
+// field that has no rule
+type UnexpectedFieldError struct {
+	Field    string
+	FileName string
+}
+
+// Error implements the error interface
+func (e *UnexpectedFieldError) Error() string {
+	return fmt.Sprintf("file %q was sent in unexpected form field %q", e.FileName, e.Field)
+}
+
+// fieldRule returns the rule for a form field. Files handled outside a form, which have no
+// field name, are not subject to field rules.
+func (t *Tools) fieldRule(field string) (FieldRule, bool) {
+	if t.FieldRules == nil || field == "" {
+		return FieldRule{}, false
+	}
+
+	rule, ok := t.FieldRules[field]
+	return rule, ok
+}
+
+// checkField rejects files sent in a form field without a rule, when rules are configured
+func (t *Tools) checkField(field, fileName string) error {
+	if t.FieldRules == nil || field == "" {
+		return nil
+	}
+
+	if _, ok := t.FieldRules[field]; !ok {
+		return &UnexpectedFieldError{Field: field, FileName: fileName}
+	}
+
+	return nil
+}
+
+// allowedFileType checks fileType against the allowed types of a form field, or AllowedFileTypes,
+// and against those of a signed upload token
+func (t *Tools) allowedFileType(field, fileType string) bool {
+	if t.policyFileTypes != nil && !matchAnyFileType(t.policyFileTypes, fileType) {
+		return false
+	}
+
+	rule, ok := t.fieldRule(field)
+	if !ok || len(rule.AllowedFileTypes) == 0 {
+		return t.CheckFileType(fileType)
+	}
+
+	return matchAnyFileType(rule.AllowedFileTypes, fileType)
+}
+
+// allowedFileTypes returns the allowed types of a form field, or AllowedFileTypes
+func (t *Tools) allowedFileTypes(field string) []string {
+	if rule, ok := t.fieldRule(field); ok && len(rule.AllowedFileTypes) > 0 {
+		return rule.AllowedFileTypes
+	}
+
+	if len(t.AllowedFileTypes) == 0 {
+		return defaultAllowedFileTypes
+	}
+
+	return t.AllowedFileTypes
+}
+
+// UploadFilesByField uploads files like UploadFiles, and returns them grouped by the name of
+// the form field they were sent in
+func (t *Tools) UploadFilesByField(r *http.Request, uploadDir string, rename ...bool) (map[string][]*UploadedFile, error) {
+	uploadedFiles, err := t.UploadFiles(r, uploadDir, rename...)
+
+	byField := make(map[string][]*UploadedFile)
+	for _, uploadedFile := range uploadedFiles {
+		byField[uploadedFile.FieldName] = append(byField[uploadedFile.FieldName], uploadedFile)
+	}
+
+	return byField, err
+}
@@ -0,0 +1,140 @@
+package toolkit
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+var fieldRulesTests = []struct {
+	name          string
+	maxAvatarSize int64
+	files         []testFormFile
+	errorExpected interface{}
+	expected      map[string]int
+}{
+	{
+		name: "avatar and attachments",
+		files: []testFormFile{
+			{field: "avatar", fileName: "me.png", content: readTestFileOnce("cyborg-ape.png")},
+			{field: "attachments", fileName: "a.pdf", content: pdfBytes},
+			{field: "attachments", fileName: "b.pdf", content: pdfBytes},
+		},
+		maxAvatarSize: 2 << 20,
+		expected:      map[string]int{"avatar": 1, "attachments": 2},
+	},
+	{
+		name:          "two avatars",
+		maxAvatarSize: 2 << 20,
+		files: []testFormFile{
+			{field: "avatar", fileName: "me.png", content: readTestFileOnce("cyborg-ape.png")},
+			{field: "avatar", fileName: "me-too.png", content: readTestFileOnce("cyborg-ape.png")},
+		},
+		errorExpected: &UploadLimitError{},
+	},
+	{
+		name:          "avatar too large",
+		maxAvatarSize: 1024,
+		files:         []testFormFile{{field: "avatar", fileName: "me.png", content: readTestFileOnce("cyborg-ape.png")}},
+		errorExpected: &UploadLimitError{},
+	},
+	{
+		name:          "pdf as avatar",
+		maxAvatarSize: 2 << 20,
+		files:         []testFormFile{{field: "avatar", fileName: "me.pdf", content: pdfBytes}},
+		errorExpected: &FileTypeError{},
+	},
+	{
+		name:          "image as attachment",
+		maxAvatarSize: 2 << 20,
+		files:         []testFormFile{{field: "attachments", fileName: "me.png", content: readTestFileOnce("cyborg-ape.png")}},
+		errorExpected: &FileTypeError{},
+	},
+	{
+		name:          "unknown field",
+		maxAvatarSize: 2 << 20,
+		files:         []testFormFile{{field: "other", fileName: "a.pdf", content: pdfBytes}},
+		errorExpected: &UnexpectedFieldError{},
+	},
+}
+
+// readTestFileOnce returns the contents of a file in testdata, for use in test tables
+func readTestFileOnce(name string) []byte {
+	data, err := os.ReadFile("./testdata/" + name)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+func TestTools_UploadFilesByField(t *testing.T) {
+	for _, entry := range fieldRulesTests {
+		for _, stream := range []bool{false, true} {
+			testTools := Tools{
+				StreamUploads: stream,
+				FieldRules: map[string]FieldRule{
+					"avatar":      {AllowedFileTypes: []string{"image/*"}, MaxFileSize: entry.maxAvatarSize, MaxFileCount: 1},
+					"attachments": {AllowedFileTypes: []string{"application/pdf"}, MaxFileCount: 10},
+				},
+			}
+
+			byField, err := testTools.UploadFilesByField(newMultipartRequest(t, entry.files...), t.TempDir())
+
+			if entry.errorExpected != nil {
+				switch entry.errorExpected.(type) {
+				case *UploadLimitError:
+					var limitErr *UploadLimitError
+					if !errors.As(err, &limitErr) {
+						t.Errorf("%s (stream %v): expected UploadLimitError, got %v", entry.name, stream, err)
+					} else if limitErr.Field != "avatar" {
+						t.Errorf("%s (stream %v): expected field avatar, got %q", entry.name, stream, limitErr.Field)
+					}
+				case *FileTypeError:
+					var typeErr *FileTypeError
+					if !errors.As(err, &typeErr) {
+						t.Errorf("%s (stream %v): expected FileTypeError, got %v", entry.name, stream, err)
+					}
+				case *UnexpectedFieldError:
+					var fieldErr *UnexpectedFieldError
+					if !errors.As(err, &fieldErr) {
+						t.Errorf("%s (stream %v): expected UnexpectedFieldError, got %v", entry.name, stream, err)
+					}
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s (stream %v): %v", entry.name, stream, err)
+				continue
+			}
+
+			for field, count := range entry.expected {
+				if len(byField[field]) != count {
+					t.Errorf("%s (stream %v): expected %d files for %s, got %d", entry.name, stream, count, field, len(byField[field]))
+				}
+				for _, uploadedFile := range byField[field] {
+					if uploadedFile.FieldName != field {
+						t.Errorf("%s (stream %v): expected field name %s, got %s", entry.name, stream, field, uploadedFile.FieldName)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTools_FieldRulesFallback(t *testing.T) {
+	testTools := Tools{FieldRules: map[string]FieldRule{"file": {}}}
+
+	if !testTools.allowedFileType("file", "image/png") {
+		t.Error("expected field without allowed types to use the default types")
+	}
+
+	if testTools.allowedFileType("file", "text/plain") {
+		t.Error("expected text/plain to be rejected by the default types")
+	}
+
+	if err := testTools.checkField("", "a.png"); err != nil {
+		t.Errorf("expected files without a field to be accepted, got %v", err)
+	}
+}
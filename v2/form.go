@@ -0,0 +1,149 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFormValuesSize limits the non-file values read from a streamed form, matching the limit
+// mime/multipart applies to parsed forms
+const maxFormValuesSize = 10 << 20
+
+// UploadForm uploads files like UploadFiles, and decodes the other values of the form into data,
+// which must be a pointer to a struct. Struct fields are matched by their `form` tag, or by their
+// name if they have none. A tag of "-" skips the field, and the option "required" rejects a
+// missing or empty value, e.g. `form:"title,required"`. Unknown values are rejected unless
+// AllowUnknownFields is set. No file is kept if the values cannot be decoded: a parsed form is
+// decoded before its files are handled, and a streamed form, whose values may follow its files,
+// is always uploaded as with AtomicUploads.
+func (t *Tools) UploadForm(r *http.Request, uploadDir string, data interface{}, rename ...bool) ([]*UploadedFile, error) {
+	if data == nil {
+		return nil, fmt.Errorf("error decoding form: data must be a pointer to a struct")
+	}
+
+	renameFile := true
+
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return t.UploadFilesWithOptions(r, uploadDir, UploadOptions{KeepFileName: !renameFile, Form: data})
+}
+
+// decodeForm decodes form values into the struct data points to
+func (t *Tools) decodeForm(values url.Values, data interface{}) error {
+	rv := reflect.ValueOf(data)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error decoding form: data must be a pointer to a struct, got %T", data)
+	}
+	rv = rv.Elem()
+
+	known := make(map[string]bool)
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("form"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[name] = true
+
+		value := values[name]
+		if len(value) == 0 || (len(value) == 1 && value[0] == "") {
+			if options == "required" {
+				return fmt.Errorf("form field %q must not be empty", name)
+			}
+			continue
+		}
+
+		if err := setFormField(rv.Field(i), name, value); err != nil {
+			return err
+		}
+	}
+
+	if !t.AllowUnknownFields {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !known[name] {
+				return fmt.Errorf("form contains unknown field %q", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setFormField sets a struct field from its form values. Slices take every value, other
+// fields the first one.
+func setFormField(field reflect.Value, name string, values []string) error {
+	if field.Kind() != reflect.Slice {
+		return setFormValue(field, name, values[0])
+	}
+
+	slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+	for i, value := range values {
+		if err := setFormValue(slice.Index(i), name, value); err != nil {
+			return err
+		}
+	}
+	field.Set(slice)
+
+	return nil
+}
+
+// setFormValue parses a single form value into field
+func setFormValue(field reflect.Value, name, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("form field %q must be true or false", name)
+		}
+		field.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("form field %q must be a whole number", name)
+		}
+		field.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("form field %q must be a positive whole number", name)
+		}
+		field.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("form field %q must be a number", name)
+		}
+		field.SetFloat(f)
+
+	default:
+		return fmt.Errorf("error decoding form: field %q has unsupported type %s", name, field.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package toolkit
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type testUploadForm struct {
+	Title       string   `form:"title,required"`
+	Description string   `form:"description"`
+	Public      bool     `form:"public"`
+	Rating      int      `form:"rating"`
+	Tags        []string `form:"tag"`
+	Ignored     string   `form:"-"`
+}
+
+// newFormRequest returns a multipart POST request with the given values followed by files
+func newFormRequest(t *testing.T, values url.Values, files ...testFormFile) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for key, vals := range values {
+		for _, value := range vals {
+			if err := writer.WriteField(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, f := range files {
+		part, err := writer.CreateFormFile(f.field, f.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(f.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return request
+}
+
+var uploadFormTests = []struct {
+	name          string
+	values        url.Values
+	allowUnknown  bool
+	errorExpected string
+}{
+	{name: "valid", values: url.Values{"title": {"Ape"}, "description": {"a cyborg ape"}, "public": {"true"}, "rating": {"5"}, "tag": {"ape", "cyborg"}}},
+	{name: "missing title", values: url.Values{"description": {"a cyborg ape"}}, errorExpected: `form field "title" must not be empty`},
+	{name: "bad number", values: url.Values{"title": {"Ape"}, "rating": {"five"}}, errorExpected: `form field "rating" must be a whole number`},
+	{name: "bad bool", values: url.Values{"title": {"Ape"}, "public": {"maybe"}}, errorExpected: `form field "public" must be true or false`},
+	{name: "unknown field", values: url.Values{"title": {"Ape"}, "author": {"me"}}, errorExpected: `form contains unknown field "author"`},
+	{name: "unknown field allowed", values: url.Values{"title": {"Ape"}, "author": {"me"}}, allowUnknown: true},
+	{name: "skipped field", values: url.Values{"title": {"Ape"}, "Ignored": {"x"}}, errorExpected: `form contains unknown field "Ignored"`},
+}
+
+func TestTools_UploadForm(t *testing.T) {
+	for _, entry := range uploadFormTests {
+		for _, stream := range []bool{false, true} {
+			testTools := Tools{StreamUploads: stream, AllowUnknownFields: entry.allowUnknown, AtomicUploads: true}
+			uploadDir := t.TempDir()
+
+			request := newFormRequest(t, entry.values, testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")})
+
+			var form testUploadForm
+			uploadedFiles, err := testTools.UploadForm(request, uploadDir, &form)
+
+			if entry.errorExpected != "" {
+				if err == nil || err.Error() != entry.errorExpected {
+					t.Errorf("%s (stream %v): expected error %q, got %v", entry.name, stream, entry.errorExpected, err)
+				}
+				if len(uploadedFiles) != 0 {
+					t.Errorf("%s (stream %v): expected no files to be kept", entry.name, stream)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s (stream %v): %v", entry.name, stream, err)
+				continue
+			}
+
+			if len(uploadedFiles) != 1 {
+				t.Errorf("%s (stream %v): expected 1 file, got %d", entry.name, stream, len(uploadedFiles))
+			}
+
+			if form.Title != "Ape" {
+				t.Errorf("%s (stream %v): expected title Ape, got %q", entry.name, stream, form.Title)
+			}
+
+			if entry.name == "valid" {
+				if form.Description != "a cyborg ape" || !form.Public || form.Rating != 5 || len(form.Tags) != 2 {
+					t.Errorf("%s (stream %v): unexpected form %+v", entry.name, stream, form)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_UploadFormInvalidData(t *testing.T) {
+	var testTools Tools
+
+	request := newFormRequest(t, url.Values{"title": {"Ape"}})
+
+	var notAStruct string
+	if _, err := testTools.UploadForm(request, t.TempDir(), &notAStruct); err == nil {
+		t.Error("expected error for data that is not a pointer to a struct")
+	}
+}
+
+func TestTools_UploadFormDecodeErrorKeepsNoFiles(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, StreamUploads: stream}
+
+		request := newFormRequest(t, url.Values{"description": {"a cyborg ape"}}, testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")})
+
+		var form testUploadForm
+		uploadedFiles, err := testTools.UploadForm(request, "uploads", &form)
+		if err == nil {
+			t.Errorf("stream %v: expected error for missing title", stream)
+		}
+
+		if len(uploadedFiles) != 0 {
+			t.Errorf("stream %v: expected no files to be returned, got %d", stream, len(uploadedFiles))
+		}
+
+		if names, _ := store.List("uploads"); len(names) != 0 {
+			t.Errorf("stream %v: expected no files to be kept, found %v", stream, names)
+		}
+	}
+}
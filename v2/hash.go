@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// hashFuncs maps the digest names accepted in Tools.Digests to their constructors
+var hashFuncs = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// digester computes the SHA-256 and any additional digests of everything written to it
+type digester struct {
+	sha256 hash.Hash
+	extra  map[string]hash.Hash
+	w      io.Writer
+}
+
+// newDigester returns a digester for SHA-256 plus the digests named in t.Digests
+func (t *Tools) newDigester() (*digester, error) {
+	d := &digester{sha256: sha256.New()}
+	writers := []io.Writer{d.sha256}
+
+	for _, name := range t.Digests {
+		name = strings.ToLower(name)
+		newHash, ok := hashFuncs[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported digest %q", name)
+		}
+		if d.extra == nil {
+			d.extra = make(map[string]hash.Hash)
+		}
+		h := newHash()
+		d.extra[name] = h
+		writers = append(writers, h)
+	}
+	d.w = io.MultiWriter(writers...)
+
+	return d, nil
+}
+
+// Write implements io.Writer
+func (d *digester) Write(p []byte) (int, error) {
+	return d.w.Write(p)
+}
+
+// record stores the hex encoded digests on uploadedFile
+func (d *digester) record(uploadedFile *UploadedFile) {
+	uploadedFile.SHA256 = hex.EncodeToString(d.sha256.Sum(nil))
+
+	if len(d.extra) == 0 {
+		return
+	}
+
+	uploadedFile.Digests = make(map[string]string, len(d.extra))
+	for name, h := range d.extra {
+		uploadedFile.Digests[name] = hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// contentAddressedName returns the file name derived from the SHA-256 of an uploaded file,
+// keeping the extension of its sanitized name if it fits within MaxFileNameLength
+func (t *Tools) contentAddressedName(uploadedFile *UploadedFile) string {
+	ext := strings.ToLower(filepath.Ext(t.SanitizeFileName(uploadedFile.OriginalFileName)))
+	if len(uploadedFile.SHA256)+len(ext) > t.maxFileNameLength() {
+		ext = ""
+	}
+
+	return uploadedFile.SHA256 + ext
+}
+
+// isDuplicate reports whether name is already stored or pending in batch
+func (t *Tools) isDuplicate(name string, batch *uploadBatch) bool {
+	for _, p := range batch.pending {
+		if p.name == name {
+			return true
+		}
+	}
+
+	_, err := t.storage().Stat(name)
+	return err == nil
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadFilesDigests(t *testing.T) {
+	content := []byte("%PDF-1.4 digest test")
+	sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content)
+
+	testTools := Tools{Storage: NewMemoryStorage(), Digests: []string{"MD5"}}
+
+	uploadedFiles, err := testTools.UploadFiles(newMultipartRequest(t, testFormFile{field: "file", fileName: "a.pdf", content: content}), "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploadedFiles[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected SHA-256 %s", uploadedFiles[0].SHA256)
+	}
+
+	if uploadedFiles[0].Digests["md5"] != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("unexpected MD5 %s", uploadedFiles[0].Digests["md5"])
+	}
+
+	testTools.Digests = []string{"crc32"}
+	_, err = testTools.UploadFiles(newMultipartRequest(t, testFormFile{field: "file", fileName: "a.pdf", content: content}), "uploads")
+	if err == nil {
+		t.Error("expected error for unsupported digest")
+	}
+}
+
+var contentAddressedTests = []struct {
+	name   string
+	atomic bool
+}{
+	{name: "not atomic", atomic: false},
+	{name: "atomic", atomic: true},
+}
+
+func TestTools_UploadFilesContentAddressed(t *testing.T) {
+	content := []byte("%PDF-1.4 same content")
+	sum := sha256.Sum256(content)
+	expectedName := hex.EncodeToString(sum[:]) + ".pdf"
+
+	for _, entry := range contentAddressedTests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, ContentAddressed: true, AtomicUploads: entry.atomic}
+
+		request := newMultipartRequest(t,
+			testFormFile{field: "file", fileName: "a.PDF", content: content},
+			testFormFile{field: "file", fileName: "b.pdf", content: content},
+		)
+
+		uploadedFiles, err := testTools.UploadFiles(request, "uploads")
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+
+		if uploadedFiles[0].NewFileName != expectedName || uploadedFiles[1].NewFileName != expectedName {
+			t.Errorf("%s: expected both files to be named %s", entry.name, expectedName)
+		}
+
+		if uploadedFiles[0].Duplicate || !uploadedFiles[1].Duplicate {
+			t.Errorf("%s: expected only the second file to be a duplicate", entry.name)
+		}
+
+		names, _ := store.List("uploads")
+		if len(names) != 1 {
+			t.Errorf("%s: expected a single stored file, got %v", entry.name, names)
+		}
+
+		// a later upload of the same content reuses the stored file
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "c.pdf", content: content}), "uploads")
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+		if !uploadedFile.Duplicate {
+			t.Errorf("%s: expected later upload to be a duplicate", entry.name)
+		}
+	}
+}
+
+func TestTools_ContentAddressedName(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	hash := hex.EncodeToString(sum[:])
+
+	var tests = []struct {
+		name         string
+		tools        Tools
+		fileName     string
+		expectedName string
+	}{
+		{name: "extension", fileName: "a.PDF", expectedName: hash + ".pdf"},
+		{name: "no extension", fileName: "readme", expectedName: hash},
+		{name: "unsafe extension", fileName: `a.p"d<f`, expectedName: hash + ".p_d_f"},
+		{name: "long extension", fileName: "a." + strings.Repeat("x", 300), expectedName: hash},
+		{name: "extension too long for the limit", tools: Tools{MaxFileNameLength: 66}, fileName: "a.pdf", expectedName: hash},
+	}
+
+	for _, e := range tests {
+		name := e.tools.contentAddressedName(&UploadedFile{OriginalFileName: e.fileName, SHA256: hash})
+		if name != e.expectedName {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expectedName, name)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"context"
+	"time"
+)
+
+// UploadHooks are called as uploads are handled by UploadFiles, HandleFile, TusHandler and the
+// other upload methods. Every file that reaches OnFileAccepted later reaches either OnFileStored
+// or OnFileRejected. The context is the one of the upload, usually the request context.
+type UploadHooks struct {
+	// OnFileAccepted is called once a file has passed the type checks, before it is written.
+	// Its FieldName, OriginalFileName, NewFileName and ContentType are set. Returning an error
+	// rejects the file. With UploadWorkers set it may be called from several goroutines at once.
+	OnFileAccepted func(ctx context.Context, uploadedFile *UploadedFile) error
+
+	// OnFileRejected is called for every file that is not kept, with the error that caused it.
+	// The files of a discarded atomic batch get the error that failed the batch. A file
+	// rejected before it was accepted only has its FieldName and OriginalFileName set.
+	OnFileRejected func(ctx context.Context, uploadedFile *UploadedFile, err error)
+
+	// OnFileStored is called once a file is in place
+	OnFileStored func(ctx context.Context, uploadedFile *UploadedFile)
+
+	// OnUploadComplete is called when an upload method returns, with its results
+	OnUploadComplete func(ctx context.Context, uploadedFiles []*UploadedFile, err error)
+}
+
+// UploadEventType identifies the hook an UploadEvent was emitted for
+type UploadEventType string
+
+const (
+	EventFileAccepted   UploadEventType = "file_accepted"
+	EventFileRejected   UploadEventType = "file_rejected"
+	EventFileStored     UploadEventType = "file_stored"
+	EventUploadComplete UploadEventType = "upload_complete"
+)
+
+// UploadEvent is sent to Tools.Events after each of the UploadHooks. File and Files are copies,
+// so events can be handled after the upload has moved on.
+type UploadEvent struct {
+	Type  UploadEventType `json:"type"`
+	Time  time.Time       `json:"time"`
+	File  *UploadedFile   `json:"file,omitempty"`  // the file, for file events
+	Files []*UploadedFile `json:"files,omitempty"` // the files saved, for EventUploadComplete
+	Err   error           `json:"-"`
+	Error string          `json:"error,omitempty"` // the message of Err
+}
+
+// EventPublisher receives upload events, e.g. to forward them to an indexer, an audit log or a
+// message broker. Publish is called synchronously, and with UploadWorkers set possibly from
+// several goroutines at once.
+type EventPublisher interface {
+	Publish(ctx context.Context, event UploadEvent)
+}
+
+// EventFunc is an EventPublisher that calls itself
+type EventFunc func(ctx context.Context, event UploadEvent)
+
+// Publish implements EventPublisher
+func (f EventFunc) Publish(ctx context.Context, event UploadEvent) {
+	f(ctx, event)
+}
+
+// eventChannelTimeout is how long EventChannel waits for room in a full channel when the upload
+// context can never be cancelled
+var eventChannelTimeout = 5 * time.Second
+
+// EventChannel is an EventPublisher that sends events to a channel. Publish waits for room in
+// the channel, dropping the event once ctx is done or, for a context that can never be
+// cancelled such as context.Background, after 5 seconds. The channel should be buffered and
+// drained by another goroutine.
+type EventChannel chan<- UploadEvent
+
+// Publish implements EventPublisher
+func (c EventChannel) Publish(ctx context.Context, event UploadEvent) {
+	select {
+	case c <- event:
+		return
+	default:
+	}
+
+	if ctx.Done() == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, eventChannelTimeout)
+		defer cancel()
+	}
+
+	select {
+	case c <- event:
+	case <-ctx.Done():
+	}
+}
+
+// fileAccepted calls OnFileAccepted for a file that passed the type checks
+func (t *Tools) fileAccepted(batch *uploadBatch, uploadedFile *UploadedFile) error {
+	ctx := batch.context()
+
+	if t.Hooks.OnFileAccepted != nil {
+		if err := t.Hooks.OnFileAccepted(ctx, uploadedFile); err != nil {
+			return err
+		}
+	}
+
+	t.publish(ctx, UploadEvent{Type: EventFileAccepted, File: uploadedFile})
+
+	return nil
+}
+
+// fileRejected calls OnFileRejected for a file that is not kept
+func (t *Tools) fileRejected(batch *uploadBatch, uploadedFile *UploadedFile, err error) {
+	ctx := batch.context()
+
+	if t.Hooks.OnFileRejected != nil {
+		t.Hooks.OnFileRejected(ctx, uploadedFile, err)
+	}
+
+	t.publish(ctx, UploadEvent{Type: EventFileRejected, File: uploadedFile, Err: err})
+}
+
+// fileStored calls OnFileStored for a file that was moved into place
+func (t *Tools) fileStored(batch *uploadBatch, uploadedFile *UploadedFile) {
+	ctx := batch.context()
+
+	if t.Hooks.OnFileStored != nil {
+		t.Hooks.OnFileStored(ctx, uploadedFile)
+	}
+
+	t.publish(ctx, UploadEvent{Type: EventFileStored, File: uploadedFile})
+}
+
+// uploadComplete calls OnUploadComplete with the results of an upload method
+func (t *Tools) uploadComplete(batch *uploadBatch, uploadedFiles []*UploadedFile, err error) {
+	ctx := batch.context()
+
+	if t.Hooks.OnUploadComplete != nil {
+		t.Hooks.OnUploadComplete(ctx, uploadedFiles, err)
+	}
+
+	t.publish(ctx, UploadEvent{Type: EventUploadComplete, Files: uploadedFiles, Err: err})
+}
+
+// completeFile calls OnUploadComplete for an upload method that saves a single file and returns
+// its results unchanged
+func (t *Tools) completeFile(batch *uploadBatch, uploadedFile *UploadedFile, err error) (*UploadedFile, error) {
+	var uploadedFiles []*UploadedFile
+	if uploadedFile != nil {
+		uploadedFiles = append(uploadedFiles, uploadedFile)
+	}
+	t.uploadComplete(batch, uploadedFiles, err)
+
+	return uploadedFile, err
+}
+
+// publish sends event to Events, copying the files it refers to
+func (t *Tools) publish(ctx context.Context, event UploadEvent) {
+	if t.Events == nil {
+		return
+	}
+
+	event.Time = time.Now()
+	if event.File != nil {
+		file := *event.File
+		event.File = &file
+	}
+	if event.Files != nil {
+		files := make([]*UploadedFile, len(event.Files))
+		for i, f := range event.Files {
+			file := *f
+			files[i] = &file
+		}
+		event.Files = files
+	}
+	if event.Err != nil {
+		event.Error = event.Err.Error()
+	}
+
+	t.Events.Publish(ctx, event)
+}
+
+// rejectedFile returns the UploadedFile reported for a file rejected before it was accepted
+func rejectedFile(field, fileName string) *UploadedFile {
+	return &UploadedFile{FieldName: field, OriginalFileName: fileName}
+}
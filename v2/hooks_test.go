@@ -0,0 +1,223 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventRecorder collects events as "type name" strings
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+// Publish implements EventPublisher
+func (e *eventRecorder) Publish(ctx context.Context, event UploadEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch {
+	case event.File != nil:
+		e.events = append(e.events, string(event.Type)+" "+event.File.OriginalFileName)
+	case event.Err != nil:
+		e.events = append(e.events, string(event.Type)+" error")
+	default:
+		e.events = append(e.events, string(event.Type))
+	}
+}
+
+func TestTools_UploadHooks(t *testing.T) {
+	var tests = []struct {
+		name           string
+		atomic         bool
+		workers        int
+		files          []testFormFile
+		acceptErr      error
+		expectedEvents []string
+	}{
+		{
+			name:  "stored and rejected",
+			files: []testFormFile{{"a", "a.pdf", pdfBytes}, {"b", "b.txt", []byte("plain text")}},
+			expectedEvents: []string{
+				"file_accepted a.pdf", "file_stored a.pdf",
+				"file_rejected b.txt",
+				"upload_complete error",
+			},
+		},
+		{
+			name:   "atomic batch discarded",
+			atomic: true,
+			files:  []testFormFile{{"a", "a.pdf", pdfBytes}, {"b", "b.txt", []byte("plain text")}},
+			expectedEvents: []string{
+				"file_accepted a.pdf",
+				"file_rejected b.txt",
+				"file_rejected a.pdf",
+				"upload_complete error",
+			},
+		},
+		{
+			name:      "rejected by hook",
+			files:     []testFormFile{{"a", "a.pdf", pdfBytes}},
+			acceptErr: errors.New("not today"),
+			expectedEvents: []string{
+				"file_rejected a.pdf",
+				"upload_complete error",
+			},
+		},
+		{
+			name:    "parallel atomic batch",
+			atomic:  true,
+			workers: 2,
+			files:   []testFormFile{{"a", "a.pdf", pdfBytes}, {"b", "b.txt", []byte("plain text")}, {"c", "c.pdf", pdfBytes}},
+			expectedEvents: []string{
+				"file_accepted a.pdf", "file_accepted c.pdf",
+				"file_rejected a.pdf", "file_rejected b.txt", "file_rejected c.pdf",
+				"upload_complete error",
+			},
+		},
+	}
+
+	type contextKey struct{}
+
+	for _, e := range tests {
+		recorder := &eventRecorder{}
+		var hookCalls []string
+		var mu sync.Mutex
+		hook := func(ctx context.Context, call string) {
+			if ctx.Value(contextKey{}) != "request" {
+				t.Errorf("%s: expected the request context in %s", e.name, call)
+			}
+			mu.Lock()
+			hookCalls = append(hookCalls, call)
+			mu.Unlock()
+		}
+
+		testTools := Tools{
+			AtomicUploads: e.atomic,
+			UploadWorkers: e.workers,
+			Events:        recorder,
+			Hooks: UploadHooks{
+				OnFileAccepted: func(ctx context.Context, uploadedFile *UploadedFile) error {
+					hook(ctx, "accepted")
+					if uploadedFile.ContentType != "application/pdf" || uploadedFile.FileSize != 0 {
+						t.Errorf("%s: unexpected accepted file %+v", e.name, uploadedFile)
+					}
+					return e.acceptErr
+				},
+				OnFileRejected: func(ctx context.Context, uploadedFile *UploadedFile, err error) {
+					hook(ctx, "rejected")
+					if err == nil {
+						t.Errorf("%s: expected an error for rejected file %s", e.name, uploadedFile.OriginalFileName)
+					}
+				},
+				OnFileStored: func(ctx context.Context, uploadedFile *UploadedFile) {
+					hook(ctx, "stored")
+					if uploadedFile.Path == "" || uploadedFile.FileSize != int64(len(pdfBytes)) {
+						t.Errorf("%s: unexpected stored file %+v", e.name, uploadedFile)
+					}
+				},
+				OnUploadComplete: func(ctx context.Context, uploadedFiles []*UploadedFile, err error) {
+					hook(ctx, "complete")
+				},
+			},
+		}
+
+		request := newMultipartRequest(t, e.files...)
+		request = request.WithContext(context.WithValue(request.Context(), contextKey{}, "request"))
+
+		_, _ = testTools.UploadFiles(request, t.TempDir(), false)
+
+		events := recorder.events
+		if e.workers > 1 {
+			// accepted events come from the workers in any order
+			sort.Strings(events[:2])
+		}
+		if !reflect.DeepEqual(events, e.expectedEvents) {
+			t.Errorf("%s: expected events %v, got %v", e.name, e.expectedEvents, events)
+		}
+
+		calls := strings.Join(hookCalls, " ")
+		if !strings.HasSuffix(calls, "complete") || strings.Count(calls, "complete") != 1 {
+			t.Errorf("%s: expected OnUploadComplete once and last, got %v", e.name, hookCalls)
+		}
+		if terminal := strings.Count(calls, "stored") + strings.Count(calls, "rejected"); terminal != len(e.files) {
+			t.Errorf("%s: expected every file to be stored or rejected once, got %v", e.name, hookCalls)
+		}
+	}
+}
+
+func TestTools_HandleFileHooks(t *testing.T) {
+	var completed []*UploadedFile
+	testTools := Tools{Hooks: UploadHooks{
+		OnUploadComplete: func(ctx context.Context, uploadedFiles []*UploadedFile, err error) {
+			completed = uploadedFiles
+		},
+	}}
+
+	request := newMultipartRequest(t, testFormFile{"file", "a.pdf", pdfBytes})
+	if err := request.ParseMultipartForm(multipartMaxMemory); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadedFile, err := testTools.HandleFile(request.MultipartForm.File["file"][0], t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(completed) != 1 || completed[0] != uploadedFile {
+		t.Errorf("expected OnUploadComplete with the handled file, got %v", completed)
+	}
+}
+
+func TestEventChannel_Publish(t *testing.T) {
+	events := make(chan UploadEvent, 1)
+	testTools := Tools{Events: EventChannel(events)}
+
+	uploadedFile := &UploadedFile{OriginalFileName: "a.pdf"}
+	testTools.fileStored(&uploadBatch{}, uploadedFile)
+
+	event := <-events
+	if event.Type != EventFileStored || event.File.OriginalFileName != "a.pdf" || event.Time.IsZero() {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.File == uploadedFile {
+		t.Error("expected the event to hold a copy of the file")
+	}
+
+	// a full channel only blocks until the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	EventChannel(events).Publish(ctx, UploadEvent{Type: EventFileAccepted})
+	EventChannel(events).Publish(ctx, UploadEvent{Type: EventFileRejected, Err: errors.New("rejected")})
+
+	if event := <-events; event.Type != EventFileAccepted {
+		t.Errorf("expected the first event to be kept, got %+v", event)
+	}
+
+	// a context that is never done only blocks until the timeout
+	defer func(timeout time.Duration) { eventChannelTimeout = timeout }(eventChannelTimeout)
+	eventChannelTimeout = 10 * time.Millisecond
+
+	EventChannel(events).Publish(context.Background(), UploadEvent{Type: EventFileAccepted})
+	published := make(chan struct{})
+	go func() {
+		EventChannel(events).Publish(context.Background(), UploadEvent{Type: EventFileStored})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expected Publish to give up on a full channel")
+	}
+
+	if event := <-events; event.Type != EventFileAccepted {
+		t.Errorf("expected the first event to be kept, got %+v", event)
+	}
+}
@@ -0,0 +1,227 @@
+package toolkit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	LimitImageWidth  UploadLimit = "MaxImageWidth"
+	LimitImageHeight UploadLimit = "MaxImageHeight"
+	LimitImagePixels UploadLimit = "MaxImagePixels"
+)
+
+// imageConfigDecoders maps the image types inspected on upload to their header decoders
+var imageConfigDecoders = map[string]func(io.Reader) (image.Config, error){
+	"image/png":  png.DecodeConfig,
+	"image/jpeg": jpeg.DecodeConfig,
+	"image/gif":  gif.DecodeConfig,
+}
+
+// isInspectedImage reports whether contentType is an image type whose metadata is read on upload
+func isInspectedImage(contentType string) bool {
+	_, ok := imageConfigDecoders[contentType]
+	return ok
+}
+
+// inspectImage reads the dimensions and frame count of the stored image name without decoding
+// its pixels, records them on uploadedFile and checks them against the image limits. An image
+// whose header cannot be read is only rejected when a limit has to be checked; otherwise it is
+// kept, as it would be without inspection, and its dimensions are left unset.
+func (t *Tools) inspectImage(name string, uploadedFile *UploadedFile) error {
+	file, err := t.storage().Get(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	config, err := imageConfigDecoders[uploadedFile.ContentType](br)
+	if err != nil {
+		if !t.limitsImages() {
+			return nil
+		}
+		return fmt.Errorf("file %q is not a valid image: %w", uploadedFile.OriginalFileName, err)
+	}
+	uploadedFile.Width = config.Width
+	uploadedFile.Height = config.Height
+
+	if err := t.checkImageSize(uploadedFile); err != nil {
+		return err
+	}
+
+	// the header decoders stop early, so count frames from the start of the file
+	frameFile, err := t.storage().Get(name)
+	if err != nil {
+		return err
+	}
+	defer frameFile.Close()
+
+	switch uploadedFile.ContentType {
+	case "image/gif":
+		uploadedFile.Frames, err = countGIFFrames(bufio.NewReader(frameFile))
+	case "image/png":
+		uploadedFile.Frames, err = countPNGFrames(bufio.NewReader(frameFile))
+	default:
+		uploadedFile.Frames = 1
+	}
+	if err != nil {
+		if !t.limitsImages() {
+			uploadedFile.Frames = 0
+			return nil
+		}
+		return fmt.Errorf("file %q is not a valid image: %w", uploadedFile.OriginalFileName, err)
+	}
+
+	return nil
+}
+
+// limitsImages reports whether any image limit is set, so that unreadable images are rejected
+func (t *Tools) limitsImages() bool {
+	return t.MaxImageWidth > 0 || t.MaxImageHeight > 0 || t.maxImagePixels() > 0
+}
+
+// checkImageSize enforces MaxImageWidth, MaxImageHeight and MaxImagePixels
+func (t *Tools) checkImageSize(uploadedFile *UploadedFile) error {
+	width, height := int64(uploadedFile.Width), int64(uploadedFile.Height)
+
+	if t.MaxImageWidth > 0 && width > int64(t.MaxImageWidth) {
+		return &UploadLimitError{FileName: uploadedFile.OriginalFileName, Limit: LimitImageWidth, Max: int64(t.MaxImageWidth)}
+	}
+
+	if t.MaxImageHeight > 0 && height > int64(t.MaxImageHeight) {
+		return &UploadLimitError{FileName: uploadedFile.OriginalFileName, Limit: LimitImageHeight, Max: int64(t.MaxImageHeight)}
+	}
+
+	if maxPixels := t.maxImagePixels(); maxPixels > 0 && width*height > maxPixels {
+		return &UploadLimitError{FileName: uploadedFile.OriginalFileName, Limit: LimitImagePixels, Max: maxPixels}
+	}
+
+	return nil
+}
+
+// maxImagePixels returns MaxImagePixels or, since derivatives decode the whole image, a default
+// when Derivatives are set
+func (t *Tools) maxImagePixels() int64 {
+	if t.MaxImagePixels == 0 && len(t.Derivatives) > 0 {
+		return defaultMaxDerivativePixels
+	}
+
+	return t.MaxImagePixels
+}
+
+// countGIFFrames walks the block structure of a GIF and counts its image descriptors
+func countGIFFrames(r *bufio.Reader) (int, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	// skip the global color table, if present
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << ((header[10] & 0x07) + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch b {
+		case 0x21: // extension
+			if _, err := r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return 0, err
+			}
+
+		case 0x2C: // image descriptor
+			frames++
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return 0, err
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err := r.Discard(3 << ((descriptor[8] & 0x07) + 1)); err != nil {
+					return 0, err
+				}
+			}
+			// LZW minimum code size, then the image data
+			if _, err := r.ReadByte(); err != nil {
+				return 0, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return 0, err
+			}
+
+		case 0x3B: // trailer
+			return frames, nil
+
+		default:
+			return 0, fmt.Errorf("unknown GIF block 0x%02x", b)
+		}
+	}
+}
+
+// skipGIFSubBlocks skips a sequence of GIF data sub-blocks up to and including the terminator
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// countPNGFrames returns the frame count of an animated PNG, or 1 for a still image
+func countPNGFrames(r *bufio.Reader) (int, error) {
+	if _, err := r.Discard(8); err != nil {
+		return 0, err
+	}
+
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, err
+		}
+		length := binary.BigEndian.Uint32(chunk[:4])
+
+		switch string(chunk[4:]) {
+		case "acTL":
+			control := make([]byte, 4)
+			if _, err := io.ReadFull(r, control); err != nil {
+				return 0, err
+			}
+			return int(binary.BigEndian.Uint32(control)), nil
+
+		case "IDAT", "IEND":
+			// the animation control chunk must come before the image data
+			return 1, nil
+		}
+
+		if length > 1<<31-1 {
+			return 0, errors.New("invalid PNG chunk length")
+		}
+		if _, err := r.Discard(int(length) + 4); err != nil {
+			return 0, err
+		}
+	}
+}
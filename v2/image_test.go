@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// testGIF returns an animated GIF with the given number of frames
+func testGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 16, 8), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var imageMetadataTests = []struct {
+	name           string
+	fileName       string
+	content        func(t *testing.T) []byte
+	expectedType   string
+	expectedWidth  int
+	expectedHeight int
+	expectedFrames int
+}{
+	{name: "png", fileName: "cyborg-ape.png", content: func(t *testing.T) []byte { return readTestFile(t, "cyborg-ape.png") }, expectedType: "image/png", expectedWidth: 400, expectedHeight: 400, expectedFrames: 1},
+	{name: "jpeg", fileName: "tipfinger.jpg", content: func(t *testing.T) []byte { return readTestFile(t, "tipfinger.jpg") }, expectedType: "image/jpeg", expectedWidth: 720, expectedHeight: 504, expectedFrames: 1},
+	{name: "animated gif", fileName: "anim.gif", content: func(t *testing.T) []byte { return testGIF(t, 3) }, expectedType: "image/gif", expectedWidth: 16, expectedHeight: 8, expectedFrames: 3},
+	{name: "pdf", fileName: "doc.pdf", content: func(t *testing.T) []byte { return []byte("%PDF-1.4") }, expectedType: "application/pdf"},
+}
+
+func TestTools_UploadFilesImageMetadata(t *testing.T) {
+	for _, entry := range imageMetadataTests {
+		testTools := Tools{Storage: NewMemoryStorage()}
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: entry.content(t)}), "uploads")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			continue
+		}
+
+		if uploadedFile.ContentType != entry.expectedType {
+			t.Errorf("%s: expected type %s, got %s", entry.name, entry.expectedType, uploadedFile.ContentType)
+		}
+
+		if uploadedFile.Width != entry.expectedWidth || uploadedFile.Height != entry.expectedHeight {
+			t.Errorf("%s: expected %dx%d, got %dx%d", entry.name, entry.expectedWidth, entry.expectedHeight, uploadedFile.Width, uploadedFile.Height)
+		}
+
+		if uploadedFile.Frames != entry.expectedFrames {
+			t.Errorf("%s: expected %d frames, got %d", entry.name, entry.expectedFrames, uploadedFile.Frames)
+		}
+	}
+}
+
+var imageLimitTests = []struct {
+	name          string
+	tools         Tools
+	expectedLimit UploadLimit
+}{
+	{name: "within limits", tools: Tools{MaxImageWidth: 400, MaxImageHeight: 400, MaxImagePixels: 160000}},
+	{name: "too wide", tools: Tools{MaxImageWidth: 399}, expectedLimit: LimitImageWidth},
+	{name: "too high", tools: Tools{MaxImageHeight: 399}, expectedLimit: LimitImageHeight},
+	{name: "too many pixels", tools: Tools{MaxImagePixels: 159999}, expectedLimit: LimitImagePixels},
+}
+
+func TestTools_UploadFilesImageLimits(t *testing.T) {
+	content := readTestFile(t, "cyborg-ape.png")
+
+	for _, entry := range imageLimitTests {
+		store := NewMemoryStorage()
+		testTools := entry.tools
+		testTools.Storage = store
+
+		_, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "ape.png", content: content}), "uploads")
+
+		if entry.expectedLimit == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			}
+			continue
+		}
+
+		var limitErr *UploadLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != entry.expectedLimit {
+			t.Errorf("%s: expected %s limit error, got %v", entry.name, entry.expectedLimit, err)
+		}
+
+		if names, _ := store.List("uploads"); len(names) != 0 {
+			t.Errorf("%s: expected rejected image to be removed, found %v", entry.name, names)
+		}
+	}
+}
+
+var invalidImageTests = []struct {
+	name          string
+	tools         Tools
+	errorExpected bool
+}{
+	{name: "no limits", tools: Tools{}},
+	{name: "pixel limit", tools: Tools{MaxImagePixels: 160000}, errorExpected: true},
+	{name: "width limit", tools: Tools{MaxImageWidth: 400}, errorExpected: true},
+	{name: "derivatives", tools: Tools{Derivatives: []Derivative{{Name: "thumb", MaxSize: 10}}}, errorExpected: true},
+}
+
+func TestTools_UploadFilesInvalidImage(t *testing.T) {
+	for _, entry := range invalidImageTests {
+		testTools := entry.tools
+		testTools.Storage = NewMemoryStorage()
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "bad.png", content: []byte("\x89PNG\r\n\x1a\nnot really")}), "uploads")
+
+		if entry.errorExpected {
+			if err == nil {
+				t.Errorf("%s: expected error for truncated png", entry.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			continue
+		}
+
+		if uploadedFile.Width != 0 || uploadedFile.Height != 0 || uploadedFile.Frames != 0 {
+			t.Errorf("%s: expected no dimensions, got %dx%d with %d frames", entry.name, uploadedFile.Width, uploadedFile.Height, uploadedFile.Frames)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"fmt"
+	"io"
+)
+
+// defaultMaxFileSize is the per-file limit used when Tools.MaxFileSize is not set
+const defaultMaxFileSize = 1024 * 1024 * 1024
+
+// multipartMaxMemory is the number of bytes of a parsed multipart form kept in memory
+// before file parts are spooled to temporary files
+const multipartMaxMemory = 32 << 20
+
+// multipartOverhead is the room left above MaxTotalUploadSize for the boundaries, part headers
+// and form values of a parsed multipart body
+const multipartOverhead = 1 << 20
+
+// UploadLimit names one of the limits that can be applied to an upload
+type UploadLimit string
+
+const (
+	LimitFileSize        UploadLimit = "MaxFileSize"
+	LimitTotalUploadSize UploadLimit = "MaxTotalUploadSize"
+	LimitFileCount       UploadLimit = "MaxFileCount"
+)
+
+// UploadLimitError is returned when an uploaded file breaks one of the upload limits
+// Field is set when the limit comes from the FieldRule of a form field.
+type UploadLimitError struct {
+	FileName string
+	Field    string
+	Limit    UploadLimit
+	Max      int64
+}
+
+// Error implements the error interface
+func (e *UploadLimitError) Error() string {
+	switch e.Limit {
+	case LimitFileCount:
+		if e.Field != "" {
+			return fmt.Sprintf("file %q exceeds the maximum of %d files for form field %q", e.FileName, e.Max, e.Field)
+		}
+		return fmt.Sprintf("file %q exceeds the maximum of %d files per upload", e.FileName, e.Max)
+	case LimitTotalUploadSize:
+		if e.FileName == "" {
+			return fmt.Sprintf("request body exceeds the maximum total upload size of %d bytes", e.Max)
+		}
+		return fmt.Sprintf("file %q exceeds the maximum total upload size of %d bytes", e.FileName, e.Max)
+	case LimitImageWidth:
+		return fmt.Sprintf("file %q exceeds the maximum image width of %d pixels", e.FileName, e.Max)
+	case LimitImageHeight:
+		return fmt.Sprintf("file %q exceeds the maximum image height of %d pixels", e.FileName, e.Max)
+	case LimitImagePixels:
+		return fmt.Sprintf("file %q exceeds the maximum image size of %d pixels", e.FileName, e.Max)
+	case LimitArchiveEntries:
+		return fmt.Sprintf("entry %q exceeds the maximum of %d archive entries", e.FileName, e.Max)
+	case LimitArchiveSize:
+		return fmt.Sprintf("entry %q exceeds the maximum uncompressed archive size of %d bytes", e.FileName, e.Max)
+	case LimitCompressionRatio:
+		return fmt.Sprintf("entry %q exceeds the maximum compression ratio of %d", e.FileName, e.Max)
+	case LimitQuotaBytes:
+		return fmt.Sprintf("file %q exceeds the remaining upload quota of %d bytes", e.FileName, e.Max)
+	case LimitQuotaFiles:
+		return fmt.Sprintf("file %q exceeds the remaining upload quota of %d files", e.FileName, e.Max)
+	default:
+		return fmt.Sprintf("file %q exceeds the maximum file size of %d bytes", e.FileName, e.Max)
+	}
+}
+
+// maxFileSize returns the configured per-file limit, or the default
+func (t *Tools) maxFileSize() int64 {
+	if t.MaxFileSize == 0 {
+		return defaultMaxFileSize
+	}
+
+	return t.MaxFileSize
+}
+
+// startFile counts a new file against the batch, enforcing MaxFileCount and the file count of
+// the form field's rule
+func (t *Tools) startFile(batch *uploadBatch, field, fileName string) error {
+	if err := t.checkField(field, fileName); err != nil {
+		return err
+	}
+
+	if t.MaxFileCount > 0 && batch.files >= t.MaxFileCount {
+		return &UploadLimitError{FileName: fileName, Limit: LimitFileCount, Max: int64(t.MaxFileCount)}
+	}
+
+	if rule, ok := t.fieldRule(field); ok && rule.MaxFileCount > 0 && batch.fieldFiles[field] >= rule.MaxFileCount {
+		return &UploadLimitError{FileName: fileName, Field: field, Limit: LimitFileCount, Max: int64(rule.MaxFileCount)}
+	}
+
+	if batch.quota != nil && batch.quota.files >= 0 && batch.files >= batch.quota.files {
+		return &UploadLimitError{FileName: fileName, Limit: LimitQuotaFiles, Max: int64(batch.quota.files)}
+	}
+
+	batch.files++
+	if batch.fieldFiles == nil {
+		batch.fieldFiles = make(map[string]int)
+	}
+	batch.fieldFiles[field]++
+
+	return nil
+}
+
+// limitReader wraps src so that reading past the per-file or total limit fails with an UploadLimitError
+func (t *Tools) limitReader(src io.Reader, batch *uploadBatch, field, fileName string) io.Reader {
+	l := &limitedReader{
+		src:      src,
+		batch:    batch,
+		fileName: fileName,
+		maxFile:  t.maxFileSize(),
+		maxTotal: t.MaxTotalUploadSize,
+	}
+
+	if rule, ok := t.fieldRule(field); ok && rule.MaxFileSize > 0 {
+		l.field, l.maxFile = field, rule.MaxFileSize
+	}
+
+	return l
+}
+
+// limitedReader counts the bytes read for one file and for the whole batch
+type limitedReader struct {
+	src      io.Reader
+	batch    *uploadBatch
+	fileName string
+	field    string
+	read     int64
+	maxFile  int64
+	maxTotal int64
+}
+
+// Read implements io.Reader
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.src.Read(p)
+	l.read += int64(n)
+
+	// files of a batch may be stored concurrently
+	l.batch.mu.Lock()
+	l.batch.total += int64(n)
+	total := l.batch.total
+	l.batch.mu.Unlock()
+
+	if l.read > l.maxFile {
+		return n, &UploadLimitError{FileName: l.fileName, Field: l.field, Limit: LimitFileSize, Max: l.maxFile}
+	}
+
+	if l.maxTotal > 0 && total > l.maxTotal {
+		return n, &UploadLimitError{FileName: l.fileName, Limit: LimitTotalUploadSize, Max: l.maxTotal}
+	}
+
+	if quota := l.batch.quota; quota != nil && quota.bytes >= 0 && total > quota.bytes {
+		return n, &UploadLimitError{FileName: l.fileName, Limit: LimitQuotaBytes, Max: quota.bytes}
+	}
+
+	return n, err
+}
@@ -0,0 +1,104 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var pngBytes = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 992)...)
+
+var pdfBytes = append([]byte("%PDF-1.4"), bytes.Repeat([]byte{0}, 992)...)
+
+var uploadLimitTests = []struct {
+	name          string
+	maxFileSize   int64
+	maxTotal      int64
+	maxCount      int
+	files         int
+	stream        bool
+	expectedLimit UploadLimit
+	expectedFile  string
+}{
+	{name: "within limits", maxFileSize: 1000, maxTotal: 3000, maxCount: 3, files: 3},
+	{name: "file too big", maxFileSize: 999, files: 1, expectedLimit: LimitFileSize, expectedFile: "0.png"},
+	{name: "total too big", maxTotal: 2500, files: 3, expectedLimit: LimitTotalUploadSize, expectedFile: "2.png"},
+	{name: "too many files", maxCount: 2, files: 3, expectedLimit: LimitFileCount, expectedFile: "2.png"},
+	{name: "stream file too big", maxFileSize: 999, files: 1, stream: true, expectedLimit: LimitFileSize, expectedFile: "0.png"},
+	{name: "stream total too big", maxTotal: 1500, files: 2, stream: true, expectedLimit: LimitTotalUploadSize, expectedFile: "1.png"},
+	{name: "stream too many files", maxCount: 1, files: 2, stream: true, expectedLimit: LimitFileCount, expectedFile: "1.png"},
+}
+
+func TestTools_UploadLimits(t *testing.T) {
+	for _, entry := range uploadLimitTests {
+		var files []testFormFile
+		for i := 0; i < entry.files; i++ {
+			files = append(files, testFormFile{field: "file", fileName: string(rune('0'+i)) + ".png", content: pngBytes})
+		}
+
+		store := NewMemoryStorage()
+		testTools := Tools{
+			Storage:            store,
+			StreamUploads:      entry.stream,
+			MaxFileSize:        entry.maxFileSize,
+			MaxTotalUploadSize: entry.maxTotal,
+			MaxFileCount:       entry.maxCount,
+		}
+
+		uploadedFiles, err := testTools.UploadFiles(newMultipartRequest(t, files...), "uploads")
+
+		if entry.expectedLimit == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			}
+			if len(uploadedFiles) != entry.files {
+				t.Errorf("%s: expected %d files, got %d", entry.name, entry.files, len(uploadedFiles))
+			}
+			continue
+		}
+
+		var limitErr *UploadLimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected UploadLimitError, got %v", entry.name, err)
+			continue
+		}
+
+		if limitErr.Limit != entry.expectedLimit {
+			t.Errorf("%s: expected limit %s, got %s", entry.name, entry.expectedLimit, limitErr.Limit)
+		}
+
+		if limitErr.FileName != entry.expectedFile {
+			t.Errorf("%s: expected file %s, got %s", entry.name, entry.expectedFile, limitErr.FileName)
+		}
+
+		names, _ := store.List("uploads")
+		if len(names) != len(uploadedFiles) {
+			t.Errorf("%s: expected partial file to be removed, found %v", entry.name, names)
+		}
+	}
+}
+
+func TestTools_UploadLimitsRequestBody(t *testing.T) {
+	content := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 2*multipartOverhead)...)
+
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, MaxTotalUploadSize: 1000}
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "big.png", content: content})
+	_, err := testTools.UploadFiles(request, "uploads")
+
+	var limitErr *UploadLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected UploadLimitError, got %v", err)
+	}
+
+	// the body is cut off while parsing, before any file is handled
+	if limitErr.Limit != LimitTotalUploadSize || limitErr.FileName != "" || limitErr.Max != 1000 {
+		t.Errorf("unexpected limit error %+v", limitErr)
+	}
+
+	names, _ := store.List("uploads")
+	if len(names) != 0 {
+		t.Errorf("expected no files to be saved, found %v", names)
+	}
+}
@@ -0,0 +1,155 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sidecarSuffix is appended to the name of a file to get the name of its sidecar
+const sidecarSuffix = ".meta.json"
+
+// FileMetadata is the record kept about an uploaded file by a MetadataStore. Uploader and
+// ClientIP are only known for files uploaded from a request.
+type FileMetadata struct {
+	Path             string    `json:"path"`
+	OriginalFileName string    `json:"original_file_name"`
+	Uploader         string    `json:"uploader,omitempty"`
+	ContentType      string    `json:"content_type"`
+	SHA256           string    `json:"sha256"`
+	FileSize         int64     `json:"file_size"`
+	UploadedAt       time.Time `json:"uploaded_at"`
+	ClientIP         string    `json:"client_ip,omitempty"`
+}
+
+// MetadataStore saves the metadata of uploaded files. Files are identified by the name they are
+// stored under, i.e. the upload directory joined with UploadedFile.Path.
+type MetadataStore interface {
+	Save(name string, metadata FileMetadata) error
+	Load(name string) (FileMetadata, error)
+	Delete(name string) error
+}
+
+// SidecarStore is a MetadataStore that writes the metadata of each file as JSON to a sidecar file
+// next to it, named after the file with ".meta.json" appended. Storage defaults to the local file system.
+type SidecarStore struct {
+	Storage Storage
+}
+
+// storage returns the configured Storage or the local file system
+func (s *SidecarStore) storage() Storage {
+	if s.Storage == nil {
+		return &FileSystemStorage{}
+	}
+
+	return s.Storage
+}
+
+// Save implements MetadataStore
+func (s *SidecarStore) Save(name string, metadata FileMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.storage().Put(name+sidecarSuffix, bytes.NewReader(data))
+	return err
+}
+
+// Load implements MetadataStore
+func (s *SidecarStore) Load(name string) (FileMetadata, error) {
+	var metadata FileMetadata
+
+	file, err := s.storage().Get(name + sidecarSuffix)
+	if err != nil {
+		return metadata, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return metadata, err
+	}
+
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
+
+// Delete implements MetadataStore
+func (s *SidecarStore) Delete(name string) error {
+	return s.storage().Delete(name + sidecarSuffix)
+}
+
+// fileMetadata returns the metadata of uploadedFile, adding what is known about the request of batch
+func (t *Tools) fileMetadata(uploadedFile *UploadedFile, batch *uploadBatch) *FileMetadata {
+	metadata := &FileMetadata{
+		Path:             uploadedFile.Path,
+		OriginalFileName: uploadedFile.OriginalFileName,
+		ContentType:      uploadedFile.ContentType,
+		SHA256:           uploadedFile.SHA256,
+		FileSize:         uploadedFile.FileSize,
+		UploadedAt:       time.Now().UTC(),
+	}
+
+	if batch.request != nil {
+		metadata.ClientIP = clientIP(batch.request)
+		if t.Uploader != nil {
+			metadata.Uploader = t.Uploader(batch.request)
+		}
+	}
+
+	return metadata
+}
+
+// clientIP returns the address of the client that sent r. Forwarding headers are not trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// displayName returns the original name of the stored file pathName from its metadata, or the
+// base name of pathName if there is none
+func (t *Tools) displayName(pathName string) string {
+	if t.Metadata != nil {
+		if metadata, err := t.Metadata.Load(pathName); err == nil && metadata.OriginalFileName != "" {
+			return metadata.OriginalFileName
+		}
+	}
+
+	return filepath.Base(pathName)
+}
+
+// contentDisposition returns the Content-Disposition header of a download saved as fileName. The
+// name is quoted or encoded, so that a name restored from an upload cannot add parameters.
+func contentDisposition(fileName string) string {
+	for _, r := range fileName {
+		if r < ' ' || r > '~' {
+			if header := mime.FormatMediaType("attachment", map[string]string{"filename": fileName}); header != "" {
+				return header
+			}
+			return "attachment"
+		}
+	}
+
+	return `attachment; filename="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fileName) + `"`
+}
+
+// FileMetadata returns the metadata saved for the stored file name
+func (t *Tools) FileMetadata(name string) (FileMetadata, error) {
+	if t.Metadata == nil {
+		return FileMetadata{}, errors.New("no MetadataStore is set")
+	}
+
+	return t.Metadata.Load(name)
+}
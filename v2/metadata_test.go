@@ -0,0 +1,137 @@
+package toolkit
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTools_UploadFilesMetadata(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{
+		Storage:  store,
+		Metadata: &SidecarStore{Storage: store},
+		Uploader: func(r *http.Request) string { return r.Header.Get("X-User") },
+	}
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "Quarterly Report.pdf", content: pdfBytes})
+	request.Header.Set("X-User", "user-1")
+	request.RemoteAddr = "192.0.2.1:1234"
+
+	uploadedFile, err := testTools.UploadFile(request, "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join("uploads", uploadedFile.Path)
+	if _, err := store.Stat(name + ".meta.json"); err != nil {
+		t.Fatalf("expected sidecar: %v", err)
+	}
+
+	metadata, err := testTools.FileMetadata(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := FileMetadata{
+		Path:             uploadedFile.Path,
+		OriginalFileName: "Quarterly Report.pdf",
+		Uploader:         "user-1",
+		ContentType:      "application/pdf",
+		SHA256:           uploadedFile.SHA256,
+		FileSize:         int64(len(pdfBytes)),
+		UploadedAt:       metadata.UploadedAt,
+		ClientIP:         "192.0.2.1",
+	}
+	if metadata != expected {
+		t.Errorf("expected %+v, got %+v", expected, metadata)
+	}
+
+	if metadata.UploadedAt.IsZero() {
+		t.Error("expected upload time to be set")
+	}
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), name, "")
+
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="Quarterly Report.pdf"` {
+		t.Errorf("expected original name to be restored, got %s", disposition)
+	}
+}
+
+func TestTools_HandleFileMetadata(t *testing.T) {
+	testTools := Tools{Metadata: &SidecarStore{}}
+	uploadDir := t.TempDir()
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "doc.pdf", content: pdfBytes})
+	if err := request.ParseMultipartForm(multipartMaxMemory); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadedFile, err := testTools.HandleFile(request.MultipartForm.File["file"][0], uploadDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(uploadDir, uploadedFile.Path)
+	if _, err := os.Stat(name + ".meta.json"); err != nil {
+		t.Fatalf("expected sidecar: %v", err)
+	}
+
+	metadata, err := testTools.FileMetadata(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.OriginalFileName != "doc.pdf" || metadata.ClientIP != "" || metadata.Uploader != "" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+}
+
+func TestTools_DownloadStaticFileWithoutMetadata(t *testing.T) {
+	testTools := Tools{Metadata: &SidecarStore{}}
+
+	rr := httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), "./testdata/tipfinger.jpg", "")
+
+	if disposition := rr.Header().Get("Content-Disposition"); disposition != `attachment; filename="tipfinger.jpg"` {
+		t.Errorf("expected base name without metadata, got %s", disposition)
+	}
+}
+
+func TestTools_DownloadStaticFileUnsafeName(t *testing.T) {
+	var tests = []struct {
+		name     string
+		fileName string
+	}{
+		{name: "quotes", fileName: `x"; filename*=UTF-8''evil.html; a=".pdf`},
+		{name: "backslash", fileName: `a\"b.pdf`},
+		{name: "unicode", fileName: "Größe.pdf"},
+		{name: "control character", fileName: "a\r\nb.pdf"},
+	}
+
+	for _, e := range tests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, Metadata: &SidecarStore{Storage: store}}
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: e.fileName, content: pdfBytes}), "uploads")
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		rr := httptest.NewRecorder()
+		testTools.DownloadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), filepath.Join("uploads", uploadedFile.Path), "")
+
+		disposition, params, err := mime.ParseMediaType(rr.Header().Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" {
+			t.Errorf("%s: invalid header %q: %v", e.name, rr.Header().Get("Content-Disposition"), err)
+			continue
+		}
+		if len(params) != 1 || params["filename"] != uploadedFile.OriginalFileName {
+			t.Errorf("%s: expected only the file name %q, got %v", e.name, uploadedFile.OriginalFileName, params)
+		}
+	}
+}
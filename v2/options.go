@@ -0,0 +1,41 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// UploadOptions are the settings of a single upload, so that the behaviour of UploadFilesContext,
+// UploadFilesWithQuota, UploadFilesWithTTL and UploadForm can be combined. The zero value
+// uploads like UploadFiles.
+type UploadOptions struct {
+	KeepFileName bool            // use the original file name instead of a random one
+	Context      context.Context // stop the upload once it is done, as with UploadFilesContext
+	Progress     ProgressFunc    // called as each file is written, if set
+	QuotaKey     string          // count the files against this key of Tools.Quota, if set
+	TTL          time.Duration   // upload temporary files with this lifetime, if set
+	Form         interface{}     // pointer to a struct the other form values are decoded into, if set
+}
+
+// UploadFilesWithOptions uploads files like UploadFiles, applying each of the options that is set
+// as the matching upload method would. A quota is reserved before any file is read and waits
+// for other uploads of the same key until opts.Context, or the context of r, is done.
+func (t *Tools) UploadFilesWithOptions(r *http.Request, uploadDir string, opts UploadOptions) ([]*UploadedFile, error) {
+	if opts.TTL < 0 {
+		return nil, errors.New("ttl must not be negative")
+	}
+
+	if opts.QuotaKey != "" && t.Quota == nil {
+		return nil, errors.New("no QuotaProvider is set")
+	}
+
+	batch := &uploadBatch{ctx: opts.Context, progress: opts.Progress, ttl: opts.TTL}
+
+	if opts.QuotaKey != "" {
+		return t.uploadFilesWithQuota(r, uploadDir, opts.QuotaKey, !opts.KeepFileName, batch, opts.Form)
+	}
+
+	return t.uploadFiles(r, uploadDir, !opts.KeepFileName, batch, opts.Form)
+}
@@ -0,0 +1,110 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+)
+
+var uploadOptionsTests = []struct {
+	name          string
+	maxFiles      int
+	files         int
+	stream        bool
+	expectedLimit UploadLimit
+	expectedFiles int
+}{
+	{name: "combined", maxFiles: 2, files: 2, expectedFiles: 2},
+	{name: "combined stream", maxFiles: 2, files: 2, stream: true, expectedFiles: 2},
+	{name: "quota exceeded", maxFiles: 1, files: 2, expectedLimit: LimitQuotaFiles, expectedFiles: 1},
+}
+
+func TestTools_UploadFilesWithOptions(t *testing.T) {
+	for _, entry := range uploadOptionsTests {
+		store := NewMemoryStorage()
+		quota := &MemoryQuota{MaxFiles: entry.maxFiles}
+		testTools := Tools{Storage: store, Quota: quota, StreamUploads: entry.stream}
+
+		var files []testFormFile
+		for i := 0; i < entry.files; i++ {
+			files = append(files, testFormFile{field: "file", fileName: "doc.pdf", content: pdfBytes})
+		}
+		request := newFormRequest(t, url.Values{"title": {"Report"}}, files...)
+
+		var progressCalls int
+		var form testUploadForm
+		uploadedFiles, err := testTools.UploadFilesWithOptions(request, "uploads", UploadOptions{
+			KeepFileName: true,
+			Context:      context.Background(),
+			Progress:     func(fileName string, written int64) { progressCalls++ },
+			QuotaKey:     "user-1",
+			TTL:          time.Hour,
+			Form:         &form,
+		})
+
+		if entry.expectedLimit != "" {
+			var limitErr *UploadLimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != entry.expectedLimit {
+				t.Errorf("%s: expected %s error, got %v", entry.name, entry.expectedLimit, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", entry.name, err)
+			continue
+		}
+
+		if len(uploadedFiles) != entry.expectedFiles {
+			t.Errorf("%s: expected %d files, got %d", entry.name, entry.expectedFiles, len(uploadedFiles))
+		}
+
+		if usage := quota.Usage("user-1"); usage.Files != entry.expectedFiles {
+			t.Errorf("%s: expected quota usage of %d files, got %d", entry.name, entry.expectedFiles, usage.Files)
+		}
+
+		if progressCalls == 0 {
+			t.Errorf("%s: expected progress to be reported", entry.name)
+		}
+
+		if len(uploadedFiles) > 0 && uploadedFiles[0].NewFileName != "doc.pdf" {
+			t.Errorf("%s: expected the original file name, got %s", entry.name, uploadedFiles[0].NewFileName)
+		}
+
+		for _, uploadedFile := range uploadedFiles {
+			if _, err := testTools.loadExpiry(path.Join("uploads", uploadedFile.Path)); err != nil {
+				t.Errorf("%s: expected %s to be temporary: %v", entry.name, uploadedFile.Path, err)
+			}
+		}
+
+		if err == nil && form.Title != "Report" {
+			t.Errorf("%s: expected the form to be decoded, got %+v", entry.name, form)
+		}
+	}
+}
+
+func TestTools_UploadFilesWithOptionsErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		tools   Tools
+		options UploadOptions
+	}{
+		{name: "no quota provider", options: UploadOptions{QuotaKey: "user-1"}},
+		{name: "negative ttl", options: UploadOptions{TTL: -time.Second}},
+	}
+
+	for _, e := range tests {
+		store := NewMemoryStorage()
+		e.tools.Storage = store
+
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: "doc.pdf", content: pdfBytes})
+		if _, err := e.tools.UploadFilesWithOptions(request, "uploads", e.options); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+
+		names, _ := store.List("uploads")
+		if len(names) != 0 {
+			t.Errorf("%s: expected no files to be saved, found %v", e.name, names)
+		}
+	}
+}
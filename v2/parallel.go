@@ -0,0 +1,102 @@
+package toolkit
+
+import (
+	"errors"
+	"mime/multipart"
+	"sync"
+)
+
+// fileJob is a file of a parsed form waiting to be handled
+type fileJob struct {
+	field      string
+	fileHeader *multipart.FileHeader
+}
+
+// storedFile is the outcome of storing a fileJob
+type storedFile struct {
+	uploadedFile *UploadedFile
+	tempName     string
+	err          error
+}
+
+// handleFiles stores the files of jobs using UploadWorkers goroutines, then names and commits them
+// one at a time in their original order, so that the results and the handling of name collisions
+// do not depend on scheduling. Every file is attempted: the files that were saved are returned
+// along with the errors of all the others, joined together.
+func (t *Tools) handleFiles(jobs []fileJob, uploadDir string, renameFile bool, batch *uploadBatch) ([]*UploadedFile, error) {
+	results := make([]storedFile, len(jobs))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(t.UploadWorkers, len(jobs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = t.storeJob(jobs[i], uploadDir, renameFile, batch)
+			}
+		}()
+	}
+
+	// files are counted in order, so that MaxFileCount always rejects the last ones
+	for i, job := range jobs {
+		if err := t.startFile(batch, job.field, job.fileHeader.Filename); err != nil {
+			results[i].err = err
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// the first error fails an atomic batch, and is reported for the files dropped with it
+	var failure error
+	for _, result := range results {
+		if result.err != nil {
+			failure = result.err
+			break
+		}
+	}
+
+	var uploadedFiles []*UploadedFile
+	var errs []error
+	for i, result := range results {
+		if result.err != nil {
+			t.fileRejected(batch, rejectedFile(jobs[i].field, jobs[i].fileHeader.Filename), result.err)
+			errs = append(errs, result.err)
+			continue
+		}
+
+		// an atomic batch with a failed file is discarded, so there is no point naming the rest
+		if batch.atomic && failure != nil {
+			_ = t.storage().Delete(result.tempName)
+			t.fileRejected(batch, result.uploadedFile, failure)
+			continue
+		}
+
+		uploadedFile, err := t.placeFile(result.tempName, uploadDir, renameFile, result.uploadedFile, batch)
+		if err != nil {
+			if failure == nil {
+				failure = err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		uploadedFiles = append(uploadedFiles, uploadedFile)
+	}
+
+	return uploadedFiles, errors.Join(errs...)
+}
+
+// storeJob opens the file of job and stores it under a temporary name
+func (t *Tools) storeJob(job fileJob, uploadDir string, renameFile bool, batch *uploadBatch) storedFile {
+	infile, err := job.fileHeader.Open()
+	if err != nil {
+		return storedFile{err: err}
+	}
+	defer infile.Close()
+
+	uploadedFile, tempName, err := t.storeFile(infile, job.field, job.fileHeader, uploadDir, renameFile, batch)
+
+	return storedFile{uploadedFile: uploadedFile, tempName: tempName, err: err}
+}
@@ -0,0 +1,109 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTools_UploadFilesParallel(t *testing.T) {
+	testTools := Tools{UploadWorkers: 4, FileNameCollision: CollisionAppendCounter}
+	uploadDir := t.TempDir()
+
+	var files []testFormFile
+	for i := 0; i < 20; i++ {
+		files = append(files, testFormFile{field: "files", fileName: fmt.Sprintf("%02d.pdf", i), content: pdfBytes})
+	}
+	files = append(files,
+		testFormFile{field: "files", fileName: "same.pdf", content: pdfBytes},
+		testFormFile{field: "files", fileName: "same.pdf", content: pdfBytes},
+	)
+
+	uploadedFiles, err := testTools.UploadFiles(newMultipartRequest(t, files...), uploadDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploadedFiles) != len(files) {
+		t.Fatalf("expected %d files, got %d", len(files), len(uploadedFiles))
+	}
+
+	for i := 0; i < 20; i++ {
+		if expected := fmt.Sprintf("%02d.pdf", i); uploadedFiles[i].NewFileName != expected {
+			t.Errorf("expected file %d to be %s, got %s", i, expected, uploadedFiles[i].NewFileName)
+		}
+	}
+
+	if uploadedFiles[20].NewFileName != "same.pdf" || uploadedFiles[21].NewFileName != "same-1.pdf" {
+		t.Errorf("expected collisions to be resolved in order, got %s and %s", uploadedFiles[20].NewFileName, uploadedFiles[21].NewFileName)
+	}
+}
+
+func TestTools_UploadFilesParallelErrors(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		testTools := Tools{UploadWorkers: 3, AtomicUploads: atomic}
+		uploadDir := t.TempDir()
+
+		request := newMultipartRequest(t,
+			testFormFile{field: "file", fileName: "a.pdf", content: pdfBytes},
+			testFormFile{field: "file", fileName: "b.txt", content: []byte("not allowed")},
+			testFormFile{field: "file", fileName: "c.pdf", content: pdfBytes},
+			testFormFile{field: "file", fileName: "d.txt", content: []byte("not allowed either")},
+		)
+
+		uploadedFiles, err := testTools.UploadFiles(request, uploadDir, false)
+
+		joined, ok := err.(interface{ Unwrap() []error })
+		if !ok || len(joined.Unwrap()) != 2 {
+			t.Fatalf("atomic %v: expected two joined errors, got %v", atomic, err)
+		}
+
+		var typeErr *FileTypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("atomic %v: expected a FileTypeError, got %v", atomic, err)
+		}
+
+		expected := 2
+		if atomic {
+			expected = 0
+		}
+
+		if len(uploadedFiles) != expected {
+			t.Errorf("atomic %v: expected %d files returned, got %d", atomic, expected, len(uploadedFiles))
+		}
+
+		entries, _ := os.ReadDir(uploadDir)
+		if len(entries) != expected {
+			t.Errorf("atomic %v: expected %d files on disk, got %d", atomic, expected, len(entries))
+		}
+
+		for _, uploadedFile := range uploadedFiles {
+			if _, err := os.Stat(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
+				t.Errorf("atomic %v: %v", atomic, err)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesParallelFileCount(t *testing.T) {
+	testTools := Tools{UploadWorkers: 4, MaxFileCount: 2}
+
+	request := newMultipartRequest(t,
+		testFormFile{field: "file", fileName: "a.pdf", content: pdfBytes},
+		testFormFile{field: "file", fileName: "b.pdf", content: pdfBytes},
+		testFormFile{field: "file", fileName: "c.pdf", content: pdfBytes},
+	)
+
+	uploadedFiles, err := testTools.UploadFiles(request, t.TempDir(), false)
+
+	var limitErr *UploadLimitError
+	if !errors.As(err, &limitErr) || limitErr.FileName != "c.pdf" {
+		t.Errorf("expected the last file to exceed the file count, got %v", err)
+	}
+
+	if len(uploadedFiles) != 2 {
+		t.Errorf("expected 2 files, got %d", len(uploadedFiles))
+	}
+}
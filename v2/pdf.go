@@ -0,0 +1,1160 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PDFFeature is a set of the kinds of active content found in a PDF
+type PDFFeature int
+
+const (
+	PDFJavaScript   PDFFeature = 1 << iota // JavaScript actions
+	PDFLaunchAction                        // actions that launch other applications
+	PDFAttachment                          // embedded files
+)
+
+// pdfFeatureNames maps the PDF names that mark active content to the feature they belong to
+var pdfFeatureNames = map[string]PDFFeature{
+	"JavaScript":     PDFJavaScript,
+	"JS":             PDFJavaScript,
+	"Launch":         PDFLaunchAction,
+	"EmbeddedFile":   PDFAttachment,
+	"EmbeddedFiles":  PDFAttachment,
+	"FileAttachment": PDFAttachment,
+}
+
+// String returns the names of the features in f
+func (f PDFFeature) String() string {
+	var names []string
+
+	if f&PDFJavaScript != 0 {
+		names = append(names, "JavaScript")
+	}
+	if f&PDFLaunchAction != 0 {
+		names = append(names, "launch actions")
+	}
+	if f&PDFAttachment != 0 {
+		names = append(names, "attachments")
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// PDFError is returned when ValidatePDF finds an uploaded PDF malformed, or containing features
+// listed in RejectPDFFeatures. Unchecked is set when RejectPDFFeatures is set and the document
+// keeps objects in encrypted object streams, which cannot be checked.
+type PDFError struct {
+	FileName  string
+	Reason    string
+	Features  PDFFeature
+	Unchecked bool
+}
+
+// Error implements the error interface
+func (e *PDFError) Error() string {
+	if e.Features != 0 {
+		return fmt.Sprintf("file %q contains rejected PDF content: %s", e.FileName, e.Features)
+	}
+
+	if e.Unchecked {
+		return fmt.Sprintf("file %q is encrypted, so its active content cannot be checked", e.FileName)
+	}
+
+	return fmt.Sprintf("file %q is not a valid PDF: %s", e.FileName, e.Reason)
+}
+
+const (
+	pdfChunkSize       = 32 << 10 // bytes read from the file at a time
+	maxPDFStreamSize   = 64 << 20 // limit on a decoded cross-reference or object stream
+	maxPDFXrefSections = 256      // limit on the chain of cross-reference sections
+	maxPDFNesting      = 64       // limit on nested arrays and dictionaries
+)
+
+// inspectPDF checks the structure of the stored PDF name and records its page count, encryption
+// and active content on uploadedFile
+func (t *Tools) inspectPDF(name string, uploadedFile *UploadedFile) error {
+	file, err := t.storage().Get(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, size, cleanup, err := readerAt(file, file, uploadedFile.FileSize)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	doc := &pdfDocument{r: r, size: size}
+	if err := doc.read(); err != nil {
+		return &PDFError{FileName: uploadedFile.OriginalFileName, Reason: err.Error()}
+	}
+
+	uploadedFile.PageCount = doc.pageCount
+	uploadedFile.PDFEncrypted = doc.encrypted
+	uploadedFile.PDFFeatures = doc.features
+
+	if rejected := doc.features & t.RejectPDFFeatures; rejected != 0 {
+		return &PDFError{FileName: uploadedFile.OriginalFileName, Features: rejected}
+	}
+
+	// active content may hide in encrypted object streams, which cannot be read
+	if t.RejectPDFFeatures != 0 && doc.unscanned {
+		return &PDFError{FileName: uploadedFile.OriginalFileName, Unchecked: true}
+	}
+
+	return nil
+}
+
+// pdfName, pdfDict and pdfRef are the PDF object types that need to be told apart; numbers are
+// int64 or float64, strings []byte and arrays []interface{}
+type (
+	pdfName string
+	pdfDict map[pdfName]interface{}
+	pdfRef  struct{ num, gen int64 }
+)
+
+// pdfCompressed locates an object stored in an object stream
+type pdfCompressed struct {
+	stream int64
+	index  int64
+}
+
+// pdfDocument is the cross-reference structure of a PDF and what was learned from it
+type pdfDocument struct {
+	r    io.ReaderAt
+	size int64
+
+	seen       map[int64]bool
+	offsets    map[int64]int64
+	compressed map[int64]pdfCompressed
+	streams    map[int64][]interface{}
+	trailer    pdfDict
+
+	pageCount int
+	encrypted bool
+	unscanned bool // objects in the object streams of an encrypted document were not scanned
+	features  PDFFeature
+}
+
+// read parses the header, cross-reference sections and trailer, counts the pages and looks for active content
+func (d *pdfDocument) read() error {
+	head := make([]byte, min(1024, d.size))
+	if _, err := d.r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Contains(head, []byte("%PDF-")) {
+		return errors.New("missing %PDF header")
+	}
+
+	if err := d.readXref(); err != nil {
+		return err
+	}
+
+	_, d.encrypted = d.trailer["Encrypt"]
+
+	// strings and streams of encrypted documents cannot be read, so objects kept in object
+	// streams are out of reach; the page tree is usually not
+	if err := d.countPages(); err != nil && !d.encrypted {
+		return err
+	}
+
+	return d.scanFeatures()
+}
+
+// readXref follows the chain of cross-reference sections from startxref
+func (d *pdfDocument) readXref() error {
+	tailSize := min(1024, d.size)
+	tail := make([]byte, tailSize)
+	if _, err := d.r.ReadAt(tail, d.size-tailSize); err != nil && err != io.EOF {
+		return err
+	}
+
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return errors.New("missing startxref")
+	}
+	if !bytes.Contains(tail[i:], []byte("%%EOF")) {
+		return errors.New("missing %%EOF marker")
+	}
+
+	p := newPDFParser(bytes.NewReader(tail[i+len("startxref"):]), int64(len(tail)-i-len("startxref")), 0)
+	offset, err := p.integer()
+	if err != nil {
+		return errors.New("invalid startxref offset")
+	}
+
+	d.seen = make(map[int64]bool)
+	d.offsets = make(map[int64]int64)
+	d.compressed = make(map[int64]pdfCompressed)
+
+	visited := make(map[int64]bool)
+	for {
+		if visited[offset] || len(visited) >= maxPDFXrefSections {
+			return errors.New("cross-reference sections form a loop")
+		}
+		visited[offset] = true
+
+		trailer, err := d.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+
+		// hybrid files keep the entries of compressed objects in a separate stream
+		if stm, ok := trailer["XRefStm"].(int64); ok && !visited[stm] {
+			visited[stm] = true
+			if _, err := d.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+
+	if _, ok := d.trailer["Root"]; !ok {
+		return errors.New("trailer has no Root")
+	}
+
+	return nil
+}
+
+// readXrefSection reads a cross-reference table or stream at offset and returns its trailer.
+// Sections are read newest first, so entries already seen are kept.
+func (d *pdfDocument) readXrefSection(offset int64) (pdfDict, error) {
+	if offset < 0 || offset >= d.size {
+		return nil, fmt.Errorf("cross-reference offset %d is outside the file", offset)
+	}
+
+	p := newPDFParser(d.r, d.size, offset)
+	p.skipSpace()
+	start := p.pos
+	if p.word() != "xref" {
+		p.pos = start
+		return d.readXrefStream(offset)
+	}
+
+	for {
+		p.skipSpace()
+		start := p.pos
+		if p.word() == "trailer" {
+			break
+		}
+		p.pos = start
+
+		first, err := p.integer()
+		if err != nil {
+			return nil, errors.New("invalid cross-reference table")
+		}
+		count, err := p.integer()
+		if err != nil || count < 0 || count > d.size/20 {
+			return nil, errors.New("invalid cross-reference table")
+		}
+
+		for num := first; num < first+count; num++ {
+			objOffset, err := p.integer()
+			if err != nil {
+				return nil, errors.New("invalid cross-reference entry")
+			}
+			if _, err := p.integer(); err != nil {
+				return nil, errors.New("invalid cross-reference entry")
+			}
+			p.skipSpace()
+			kind := p.word()
+			if kind != "n" && kind != "f" {
+				return nil, errors.New("invalid cross-reference entry")
+			}
+
+			if !d.seen[num] {
+				d.seen[num] = true
+				if kind == "n" {
+					d.offsets[num] = objOffset
+				}
+			}
+		}
+	}
+
+	trailer, err := p.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trailer: %w", err)
+	}
+	dict, ok := trailer.(pdfDict)
+	if !ok {
+		return nil, errors.New("trailer is not a dictionary")
+	}
+
+	return dict, nil
+}
+
+// readXrefStream reads a cross-reference stream object at offset and returns its dictionary
+func (d *pdfDocument) readXrefStream(offset int64) (pdfDict, error) {
+	obj, err := d.objectAt(offset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cross-reference stream: %w", err)
+	}
+
+	dict, ok := obj.value.(pdfDict)
+	if !ok || dict["Type"] != pdfName("XRef") || obj.stream < 0 {
+		return nil, errors.New("startxref does not point to a cross-reference section")
+	}
+
+	data, err := d.streamData(obj)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cross-reference stream: %w", err)
+	}
+
+	widths, ok := dict["W"].([]interface{})
+	if !ok || len(widths) != 3 {
+		return nil, errors.New("invalid cross-reference stream widths")
+	}
+	var w [3]int
+	for i, width := range widths {
+		n, ok := width.(int64)
+		if !ok || n < 0 || n > 8 {
+			return nil, errors.New("invalid cross-reference stream widths")
+		}
+		w[i] = int(n)
+	}
+	entrySize := w[0] + w[1] + w[2]
+	if entrySize == 0 {
+		return nil, errors.New("invalid cross-reference stream widths")
+	}
+
+	index := []interface{}{int64(0), dict["Size"]}
+	if i, ok := dict["Index"].([]interface{}); ok {
+		index = i
+	}
+
+	for i := 0; i+1 < len(index); i += 2 {
+		first, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 || count < 0 {
+			return nil, errors.New("invalid cross-reference stream index")
+		}
+
+		for num := first; num < first+count; num++ {
+			if len(data) < entrySize {
+				return nil, errors.New("cross-reference stream is too short")
+			}
+			kind := int64(1)
+			if w[0] > 0 {
+				kind = pdfUint(data[:w[0]])
+			}
+			f2 := pdfUint(data[w[0] : w[0]+w[1]])
+			f3 := pdfUint(data[w[0]+w[1] : entrySize])
+			data = data[entrySize:]
+
+			if d.seen[num] {
+				continue
+			}
+			d.seen[num] = true
+
+			switch kind {
+			case 1:
+				d.offsets[num] = f2
+			case 2:
+				d.compressed[num] = pdfCompressed{stream: f2, index: f3}
+			}
+		}
+	}
+
+	return dict, nil
+}
+
+// pdfUint decodes a big-endian unsigned field of a cross-reference stream
+func pdfUint(b []byte) int64 {
+	var n int64
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+
+	return n
+}
+
+// pdfObject is an indirect object read from the file. stream is the offset of its stream data, or -1.
+type pdfObject struct {
+	value  interface{}
+	stream int64
+}
+
+// objectAt parses the indirect object starting at offset
+func (d *pdfDocument) objectAt(offset int64) (pdfObject, error) {
+	if offset < 0 || offset >= d.size {
+		return pdfObject{}, fmt.Errorf("object offset %d is outside the file", offset)
+	}
+
+	p := newPDFParser(d.r, d.size, offset)
+	if _, err := p.integer(); err != nil {
+		return pdfObject{}, errors.New("missing object number")
+	}
+	if _, err := p.integer(); err != nil {
+		return pdfObject{}, errors.New("missing generation number")
+	}
+	p.skipSpace()
+	if p.word() != "obj" {
+		return pdfObject{}, errors.New("missing obj keyword")
+	}
+
+	value, err := p.value(0)
+	if err != nil {
+		return pdfObject{}, err
+	}
+
+	obj := pdfObject{value: value, stream: -1}
+
+	p.skipSpace()
+	if p.word() == "stream" {
+		// the keyword is followed by CRLF or LF
+		if c, ok := p.peek(); ok && c == '\r' {
+			p.pos++
+		}
+		if c, ok := p.peek(); ok && c == '\n' {
+			p.pos++
+		}
+		obj.stream = p.offset()
+	}
+
+	return obj, nil
+}
+
+// object returns the value of object num, which is null if it does not exist
+func (d *pdfDocument) object(num int64) (interface{}, error) {
+	if offset, ok := d.offsets[num]; ok {
+		obj, err := d.objectAt(offset)
+		return obj.value, err
+	}
+
+	c, ok := d.compressed[num]
+	if !ok {
+		return nil, nil
+	}
+
+	objects, err := d.objectStream(c.stream)
+	if err != nil {
+		return nil, err
+	}
+	if c.index < 0 || c.index >= int64(len(objects)) {
+		return nil, fmt.Errorf("object %d is missing from its object stream", num)
+	}
+
+	return objects[c.index], nil
+}
+
+// resolve follows indirect references until it reaches a direct value
+func (d *pdfDocument) resolve(v interface{}) (interface{}, error) {
+	for i := 0; i < maxPDFNesting; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v, nil
+		}
+
+		var err error
+		if v, err = d.object(ref.num); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("indirect references form a loop")
+}
+
+// objectStream returns the objects kept in object stream num
+func (d *pdfDocument) objectStream(num int64) ([]interface{}, error) {
+	if objects, ok := d.streams[num]; ok {
+		return objects, nil
+	}
+
+	offset, ok := d.offsets[num]
+	if !ok {
+		return nil, fmt.Errorf("object stream %d does not exist", num)
+	}
+
+	obj, err := d.objectAt(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := obj.value.(pdfDict)
+	if !ok || obj.stream < 0 {
+		return nil, fmt.Errorf("object %d is not an object stream", num)
+	}
+
+	data, err := d.streamData(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok1 := dict["N"].(int64)
+	first, ok2 := dict["First"].(int64)
+	if !ok1 || !ok2 || n < 0 || first < 0 || first > int64(len(data)) {
+		return nil, fmt.Errorf("object stream %d has an invalid header", num)
+	}
+
+	p := newPDFParser(bytes.NewReader(data), int64(len(data)), 0)
+	offsets := make([]int64, 0, min(n, int64(len(data))))
+	for i := int64(0); i < n; i++ {
+		if _, err := p.integer(); err != nil {
+			return nil, fmt.Errorf("object stream %d has an invalid header", num)
+		}
+		off, err := p.integer()
+		if err != nil {
+			return nil, fmt.Errorf("object stream %d has an invalid header", num)
+		}
+		offsets = append(offsets, off)
+	}
+
+	objects := make([]interface{}, len(offsets))
+	for i, off := range offsets {
+		if first+off >= int64(len(data)) {
+			return nil, fmt.Errorf("object stream %d has an invalid offset", num)
+		}
+		p := newPDFParser(bytes.NewReader(data), int64(len(data)), first+off)
+		if objects[i], err = p.value(0); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.streams == nil {
+		d.streams = make(map[int64][]interface{})
+	}
+	d.streams[num] = objects
+
+	return objects, nil
+}
+
+// streamData returns the decoded data of a stream object. Only the FlateDecode filter, used for
+// cross-reference and object streams, is supported.
+func (d *pdfDocument) streamData(obj pdfObject) ([]byte, error) {
+	dict, _ := obj.value.(pdfDict)
+
+	lengthValue, err := d.resolve(dict["Length"])
+	if err != nil {
+		return nil, err
+	}
+	length, ok := lengthValue.(int64)
+	if !ok || length < 0 || obj.stream+length > d.size || length > maxPDFStreamSize {
+		return nil, errors.New("invalid stream length")
+	}
+
+	data := make([]byte, length)
+	if _, err := d.r.ReadAt(data, obj.stream); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	filter, err := d.resolve(dict["Filter"])
+	if err != nil {
+		return nil, err
+	}
+	if filters, ok := filter.([]interface{}); ok && len(filters) == 1 {
+		filter = filters[0]
+	}
+
+	switch filter {
+	case nil:
+		return data, nil
+	case pdfName("FlateDecode"):
+	default:
+		return nil, fmt.Errorf("unsupported stream filter %v", filter)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(zr, maxPDFStreamSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > maxPDFStreamSize {
+		return nil, errors.New("stream is too large")
+	}
+
+	params, _ := d.resolve(dict["DecodeParms"])
+	if list, ok := params.([]interface{}); ok && len(list) == 1 {
+		params, _ = d.resolve(list[0])
+	}
+	if params, ok := params.(pdfDict); ok {
+		predictor, _ := params["Predictor"].(int64)
+		columns, ok := params["Columns"].(int64)
+		if !ok {
+			columns = 1
+		}
+		if predictor >= 10 {
+			return unpredictPNG(decoded, int(columns))
+		}
+	}
+
+	return decoded, nil
+}
+
+// unpredictPNG reverses the PNG predictors applied to rows of columns bytes
+func unpredictPNG(data []byte, columns int) ([]byte, error) {
+	if columns <= 0 {
+		return nil, errors.New("invalid predictor columns")
+	}
+
+	rowSize := columns + 1
+	if len(data)%rowSize != 0 {
+		return nil, errors.New("invalid predictor data")
+	}
+
+	out := make([]byte, 0, len(data)/rowSize*columns)
+	prev := make([]byte, columns)
+	for len(data) > 0 {
+		filter, row := data[0], data[1:rowSize]
+		data = data[rowSize:]
+
+		for i := range row {
+			var left, upLeft byte
+			if i > 0 {
+				left, upLeft = row[i-1], prev[i-1]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("invalid PNG predictor %d", filter)
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+// paeth is the Paeth predictor of the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// countPages reads the page count from the page tree of the document catalog
+func (d *pdfDocument) countPages() error {
+	root, err := d.resolve(d.trailer["Root"])
+	if err != nil {
+		return err
+	}
+	catalog, ok := root.(pdfDict)
+	if !ok {
+		return errors.New("document catalog is missing")
+	}
+
+	pagesValue, err := d.resolve(catalog["Pages"])
+	if err != nil {
+		return err
+	}
+	pages, ok := pagesValue.(pdfDict)
+	if !ok {
+		return errors.New("page tree is missing")
+	}
+
+	count, err := d.resolve(pages["Count"])
+	if err != nil {
+		return err
+	}
+	n, ok := count.(int64)
+	if !ok || n < 0 {
+		return errors.New("page tree has an invalid page count")
+	}
+	d.pageCount = int(n)
+
+	return nil
+}
+
+// scanFeatures looks for the names that mark active content in every object the cross-reference
+// points at, in every object found by reading the file from start to end, since lenient readers
+// recover objects the cross-reference misses, and in the object streams of unencrypted documents
+func (d *pdfDocument) scanFeatures() error {
+	for num, offset := range d.offsets {
+		obj, err := d.objectAt(offset)
+		if err != nil {
+			return fmt.Errorf("object %d: %w", num, err)
+		}
+		d.features |= pdfValueFeatures(obj.value)
+	}
+
+	d.scanFile()
+
+	if d.encrypted {
+		d.unscanned = len(d.compressed) > 0
+		return nil
+	}
+
+	scanned := make(map[int64]bool)
+	for _, c := range d.compressed {
+		if scanned[c.stream] {
+			continue
+		}
+		scanned[c.stream] = true
+
+		objects, err := d.objectStream(c.stream)
+		if err != nil {
+			return err
+		}
+		for _, v := range objects {
+			d.features |= pdfValueFeatures(v)
+		}
+	}
+
+	return nil
+}
+
+// pdfValueFeatures returns the features marked by the names in v, including dictionary keys
+func pdfValueFeatures(v interface{}) PDFFeature {
+	switch v := v.(type) {
+	case pdfName:
+		return pdfFeatureNames[string(v)]
+	case pdfDict:
+		var features PDFFeature
+		for key, value := range v {
+			features |= pdfFeatureNames[string(key)] | pdfValueFeatures(value)
+		}
+		return features
+	case []interface{}:
+		var features PDFFeature
+		for _, value := range v {
+			features |= pdfValueFeatures(value)
+		}
+		return features
+	default:
+		return 0
+	}
+}
+
+// scanFile reads the names in the file from start to end. Objects are parsed where "num gen obj"
+// is found, and their stream data is skipped by its /Length; a stream keyword anywhere else, or
+// with a length that cannot be read, skips nothing.
+func (d *pdfDocument) scanFile() {
+	p := newPDFParser(d.r, d.size, 0)
+
+	// the last two words read, to recognise the start of an object
+	var words [2]string
+
+	for {
+		p.compact()
+
+		c, ok := p.peek()
+		if !ok {
+			return
+		}
+
+		switch {
+		case c == '/':
+			d.features |= pdfFeatureNames[p.name()]
+			words = [2]string{}
+		case c == '(':
+			p.literalString()
+			words = [2]string{}
+		case c == '%':
+			p.skipComment()
+		case isPDFSpace(c):
+			p.pos++
+		case isPDFDelimiter(c):
+			p.pos++
+			words = [2]string{}
+		default:
+			word := p.word()
+			if word == "obj" && isPDFInteger(words[0]) && isPDFInteger(words[1]) {
+				d.scanObject(p)
+				words = [2]string{}
+				continue
+			}
+			words = [2]string{words[1], word}
+		}
+	}
+}
+
+// scanObject parses the value of the object whose obj keyword p has just read and skips its
+// stream data. If the value cannot be parsed, p is left where it was so its names are still read.
+func (d *pdfDocument) scanObject(p *pdfParser) {
+	start := p.pos
+	value, err := p.value(0)
+	if err != nil {
+		p.pos = start
+		return
+	}
+	d.features |= pdfValueFeatures(value)
+
+	afterValue := p.pos
+	p.skipSpace()
+	if p.word() != "stream" {
+		p.pos = afterValue
+		return
+	}
+
+	dict, _ := value.(pdfDict)
+	length, err := d.resolve(dict["Length"])
+	if n, ok := length.(int64); err == nil && ok && n >= 0 {
+		if c, ok := p.peek(); ok && c == '\r' {
+			p.pos++
+		}
+		if c, ok := p.peek(); ok && c == '\n' {
+			p.pos++
+		}
+		p.skip(n)
+	}
+}
+
+// isPDFInteger reports whether word is an unsigned integer, as object and generation numbers are
+func isPDFInteger(word string) bool {
+	if word == "" {
+		return false
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < '0' || word[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pdfParser reads PDF syntax from a file, loading it in chunks as needed. data holds the file
+// from offset base on, and pos is the read position within data.
+type pdfParser struct {
+	r    io.ReaderAt
+	size int64
+	base int64
+	data []byte
+	pos  int
+}
+
+// newPDFParser returns a parser reading r, a file of the given size, from offset
+func newPDFParser(r io.ReaderAt, size, offset int64) *pdfParser {
+	return &pdfParser{r: r, size: size, base: offset}
+}
+
+// fill loads the next chunk of the file, returning false at its end
+func (p *pdfParser) fill() bool {
+	offset := p.base + int64(len(p.data))
+	if offset >= p.size {
+		return false
+	}
+
+	chunk := make([]byte, min(pdfChunkSize, p.size-offset))
+	n, _ := p.r.ReadAt(chunk, offset)
+	if n == 0 {
+		return false
+	}
+	p.data = append(p.data, chunk[:n]...)
+
+	return true
+}
+
+// compact drops the data already read, so that scanning a large file uses little memory
+func (p *pdfParser) compact() {
+	if p.pos < pdfChunkSize {
+		return
+	}
+
+	p.base += int64(p.pos)
+	p.data = append(p.data[:0], p.data[p.pos:]...)
+	p.pos = 0
+}
+
+// offset returns the file offset of the read position
+func (p *pdfParser) offset() int64 {
+	return p.base + int64(p.pos)
+}
+
+// peek returns the next byte without consuming it
+func (p *pdfParser) peek() (byte, bool) {
+	for p.pos >= len(p.data) {
+		if !p.fill() {
+			return 0, false
+		}
+	}
+
+	return p.data[p.pos], true
+}
+
+// isPDFSpace reports whether c is PDF white space
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+// isPDFDelimiter reports whether c is a PDF delimiter
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace skips white space and comments
+func (p *pdfParser) skipSpace() {
+	for {
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return
+		case isPDFSpace(c):
+			p.pos++
+		case c == '%':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+// skipComment skips a comment up to the end of its line
+func (p *pdfParser) skipComment() {
+	for {
+		c, ok := p.peek()
+		if !ok || c == '\r' || c == '\n' {
+			return
+		}
+		p.pos++
+	}
+}
+
+// skip moves the read position n bytes forward, or to the end of the file
+func (p *pdfParser) skip(n int64) {
+	p.base = min(p.offset()+n, p.size)
+	p.data = p.data[:0]
+	p.pos = 0
+}
+
+// word reads a run of regular characters
+func (p *pdfParser) word() string {
+	start := p.pos
+	for {
+		c, ok := p.peek()
+		if !ok || isPDFSpace(c) || isPDFDelimiter(c) {
+			return string(p.data[start:p.pos])
+		}
+		p.pos++
+	}
+}
+
+// integer reads an integer
+func (p *pdfParser) integer() (int64, error) {
+	p.skipSpace()
+	return strconv.ParseInt(p.word(), 10, 64)
+}
+
+// name reads a name, decoding #xx escapes
+func (p *pdfParser) name() string {
+	p.pos++
+	raw := p.word()
+	if !strings.Contains(raw, "#") {
+		return raw
+	}
+
+	var name strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if b, err := hex.DecodeString(raw[i+1 : i+3]); err == nil {
+				name.WriteByte(b[0])
+				i += 2
+				continue
+			}
+		}
+		name.WriteByte(raw[i])
+	}
+
+	return name.String()
+}
+
+// literalString reads a string in parentheses, which may contain balanced parentheses and escapes
+func (p *pdfParser) literalString() []byte {
+	p.pos++
+	start, depth := p.pos, 1
+
+	for {
+		c, ok := p.peek()
+		if !ok {
+			return p.data[start:p.pos]
+		}
+		p.pos++
+
+		switch c {
+		case '\\':
+			if _, ok := p.peek(); ok {
+				p.pos++
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return p.data[start : p.pos-1]
+			}
+		}
+	}
+}
+
+// value parses the next value
+func (p *pdfParser) value(depth int) (interface{}, error) {
+	if depth > maxPDFNesting {
+		return nil, errors.New("objects are nested too deeply")
+	}
+
+	p.skipSpace()
+	c, ok := p.peek()
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch {
+	case c == '/':
+		return pdfName(p.name()), nil
+
+	case c == '(':
+		return p.literalString(), nil
+
+	case c == '<':
+		p.pos++
+		if c, ok := p.peek(); ok && c == '<' {
+			p.pos++
+			return p.dict(depth)
+		}
+		start := p.pos
+		for {
+			c, ok := p.peek()
+			if !ok {
+				return nil, io.ErrUnexpectedEOF
+			}
+			p.pos++
+			if c == '>' {
+				return p.data[start : p.pos-1], nil
+			}
+		}
+
+	case c == '[':
+		p.pos++
+		var array []interface{}
+		for {
+			p.skipSpace()
+			if c, ok := p.peek(); ok && c == ']' {
+				p.pos++
+				return array, nil
+			}
+			v, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+
+	default:
+		switch word := p.word(); word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unexpected token %q at offset %d", word, p.offset())
+		}
+	}
+}
+
+// dict parses the rest of a dictionary after its opening <<
+func (p *pdfParser) dict(depth int) (pdfDict, error) {
+	dict := make(pdfDict)
+
+	for {
+		p.skipSpace()
+		c, ok := p.peek()
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if c == '>' {
+			p.pos++
+			if c, ok := p.peek(); !ok || c != '>' {
+				return nil, fmt.Errorf("unexpected > at offset %d", p.offset())
+			}
+			p.pos++
+			return dict, nil
+		}
+
+		key, err := p.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("dictionary key is not a name at offset %d", p.offset())
+		}
+
+		value, err := p.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dict[name] = value
+	}
+}
+
+// number parses a number, or an indirect reference "num gen R"
+func (p *pdfParser) number() (interface{}, error) {
+	word := p.word()
+
+	n, err := strconv.ParseInt(word, 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", word)
+		}
+		return f, nil
+	}
+
+	// look ahead for the generation number and R of a reference
+	start := p.pos
+	p.skipSpace()
+	if gen, err := strconv.ParseInt(p.word(), 10, 64); err == nil {
+		p.skipSpace()
+		if p.word() == "R" {
+			return pdfRef{num: n, gen: gen}, nil
+		}
+	}
+	p.pos = start
+
+	return n, nil
+}
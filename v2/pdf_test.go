@@ -0,0 +1,222 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testPDFObjects are the catalog and page tree of a two page document
+var testPDFObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+	"<< /Type /Page /Parent 2 0 R /Contents 5 0 R >>",
+	"<< /Type /Page /Parent 2 0 R >>",
+	"<< /Length 20 >>\nstream\n(/JS /Launch) Tj  \nendstream",
+}
+
+// testPDF builds a PDF with a cross-reference table from the bodies of objects 1 to n
+func testPDF(trailer string, objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+
+	return buf.Bytes()
+}
+
+// testCompressedPDF builds a PDF 1.5 file that keeps catalog and page tree in an object stream,
+// indexed by a cross-reference stream using the PNG up predictor
+func testCompressedPDF(catalog string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+
+	// objects 1 and 2 live in object stream 3
+	objects := []string{catalog, "<< /Type /Pages /Kids [] /Count 7 >>"}
+	header := fmt.Sprintf("1 0 2 %d ", len(objects[0])+1)
+	content := header + objects[0] + " " + objects[1]
+
+	var stream bytes.Buffer
+	zw := zlib.NewWriter(&stream)
+	_, _ = zw.Write([]byte(content))
+	_ = zw.Close()
+
+	objStm := buf.Len()
+	fmt.Fprintf(&buf, "3 0 obj\n<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", len(header), stream.Len())
+	buf.Write(stream.Bytes())
+	buf.WriteString("\nendstream\nendobj\n")
+
+	xref := buf.Len()
+	entries := [][3]int{{0, 0, 65535}, {2, 3, 0}, {2, 3, 1}, {1, objStm, 0}, {1, xref, 0}}
+
+	// rows of 1 + 4 + 2 bytes, each prefixed with the up predictor
+	var rows bytes.Buffer
+	prev := make([]byte, 7)
+	for _, e := range entries {
+		row := make([]byte, 7)
+		row[0] = byte(e[0])
+		binary.BigEndian.PutUint32(row[1:5], uint32(e[1]))
+		binary.BigEndian.PutUint16(row[5:7], uint16(e[2]))
+		rows.WriteByte(2)
+		for i := range row {
+			rows.WriteByte(row[i] - prev[i])
+		}
+		prev = row
+	}
+
+	stream.Reset()
+	zw = zlib.NewWriter(&stream)
+	_, _ = zw.Write(rows.Bytes())
+	_ = zw.Close()
+
+	fmt.Fprintf(&buf, "4 0 obj\n<< /Type /XRef /Size 5 /W [1 4 2] /Root 1 0 R /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 7 >> /Length %d >>\nstream\n", stream.Len())
+	buf.Write(stream.Bytes())
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	return buf.Bytes()
+}
+
+// encryptedPDF marks a PDF built by testCompressedPDF as encrypted
+func encryptedPDF(data []byte) []byte {
+	return bytes.Replace(data, []byte("/Type /XRef"), []byte("/Type /XRef /Encrypt << /Filter /Standard /V 4 >>"), 1)
+}
+
+// withCatalog returns testPDFObjects with the body of object 1 replaced
+func withCatalog(catalog string) []string {
+	objects := append([]string{}, testPDFObjects...)
+	objects[0] = catalog
+	return objects
+}
+
+var validatePDFTests = []struct {
+	name              string
+	data              []byte
+	reject            PDFFeature
+	expectedPages     int
+	expectedEncrypted bool
+	expectedFeatures  PDFFeature
+	expectedUnchecked bool
+	errorExpected     bool
+}{
+	{name: "valid", data: testPDF("", testPDFObjects...), expectedPages: 2},
+	{
+		name:             "javascript",
+		data:             testPDF("", withCatalog("<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /JavaScript /JS (app.alert(1)) >> >>")...),
+		expectedPages:    2,
+		expectedFeatures: PDFJavaScript,
+	},
+	{
+		name:             "escaped name",
+		data:             testPDF("", withCatalog("<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /J#61vaScript >> >>")...),
+		reject:           PDFJavaScript,
+		expectedFeatures: PDFJavaScript,
+		errorExpected:    true,
+	},
+	{
+		name:             "launch",
+		data:             testPDF("", withCatalog("<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /Launch /F (calc.exe) >> >>")...),
+		reject:           PDFJavaScript | PDFLaunchAction,
+		expectedFeatures: PDFLaunchAction,
+		errorExpected:    true,
+	},
+	{
+		name:             "attachment allowed",
+		data:             testPDF("", withCatalog("<< /Type /Catalog /Pages 2 0 R /Names << /EmbeddedFiles << /Names [] >> >> >>")...),
+		reject:           PDFJavaScript,
+		expectedPages:    2,
+		expectedFeatures: PDFAttachment,
+	},
+	{name: "encrypted", data: testPDF("/Encrypt << /Filter /Standard >>", testPDFObjects...), expectedPages: 2, expectedEncrypted: true},
+	{name: "compressed", data: testCompressedPDF("<< /Type /Catalog /Pages 2 0 R >>"), expectedPages: 7},
+	{
+		name:             "compressed javascript",
+		data:             testCompressedPDF("<< /Type /Catalog /Pages 2 0 R /AA << /O << /S /JavaScript >> >> >>"),
+		reject:           PDFJavaScript,
+		expectedFeatures: PDFJavaScript,
+		errorExpected:    true,
+	},
+	{
+		name:              "encrypted object stream",
+		data:              encryptedPDF(testCompressedPDF("<< /Type /Catalog /Pages 2 0 R /OpenAction << /S /JavaScript >> >>")),
+		reject:            PDFJavaScript,
+		expectedEncrypted: true,
+		expectedUnchecked: true,
+		errorExpected:     true,
+	},
+	{
+		name:              "encrypted object stream allowed",
+		data:              encryptedPDF(testCompressedPDF("<< /Type /Catalog /Pages 2 0 R >>")),
+		expectedPages:     7,
+		expectedEncrypted: true,
+	},
+	{
+		name: "javascript after fake stream",
+		data: testPDF("",
+			"<< /Type /Catalog /Pages 2 0 R /OpenAction 4 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R >>\nstream",
+			"<< /S /JavaScript /JS (app.alert(1)) >>\n% endstream",
+		),
+		reject:           PDFJavaScript,
+		expectedFeatures: PDFJavaScript,
+		errorExpected:    true,
+	},
+	{
+		name:          "stream skipped by length",
+		data:          testPDF("", append(testPDFObjects[:4:4], "<< /Length 23 >>\nstream\n(/JS) endstream /JS Tj\nendstream")...),
+		expectedPages: 2,
+	},
+	{name: "header only", data: pdfBytes, errorExpected: true},
+	{name: "bad xref offset", data: bytes.Replace(testPDF("", testPDFObjects...), []byte("startxref\n"), []byte("startxref\n9"), 1), errorExpected: true},
+	{name: "missing catalog", data: testPDF("", "<< /Type /Catalog >>"), errorExpected: true},
+}
+
+func TestTools_ValidatePDF(t *testing.T) {
+	for _, entry := range validatePDFTests {
+		testTools := Tools{ValidatePDF: true, RejectPDFFeatures: entry.reject}
+
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: "doc.pdf", content: entry.data})
+		uploadedFile, err := testTools.UploadFile(request, t.TempDir())
+
+		var pdfErr *PDFError
+		if entry.errorExpected {
+			if !errors.As(err, &pdfErr) {
+				t.Errorf("%s: expected PDFError, got %v", entry.name, err)
+			} else if pdfErr.Features != entry.expectedFeatures || pdfErr.Unchecked != entry.expectedUnchecked {
+				t.Errorf("%s: expected rejected features %v, got %v", entry.name, entry.expectedFeatures, pdfErr.Features)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", entry.name, err)
+			continue
+		}
+
+		if uploadedFile.PageCount != entry.expectedPages {
+			t.Errorf("%s: expected %d pages, got %d", entry.name, entry.expectedPages, uploadedFile.PageCount)
+		}
+
+		if uploadedFile.PDFEncrypted != entry.expectedEncrypted {
+			t.Errorf("%s: expected encrypted %v, got %v", entry.name, entry.expectedEncrypted, uploadedFile.PDFEncrypted)
+		}
+
+		if uploadedFile.PDFFeatures != entry.expectedFeatures {
+			t.Errorf("%s: expected features %v, got %v", entry.name, entry.expectedFeatures, uploadedFile.PDFFeatures)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	LimitQuotaBytes UploadLimit = "QuotaBytes"
+	LimitQuotaFiles UploadLimit = "QuotaFiles"
+)
+
+// QuotaProvider keeps track of how much each user or tenant, identified by a key, may upload.
+// Reserve and Commit bracket every upload: while an upload of a key is reserved, other uploads of
+// the same key wait in Reserve, so that concurrent requests cannot exceed the quota together.
+type QuotaProvider interface {
+	// Reserve waits until no other upload of key is reserved, or ctx is done, and returns the
+	// number of bytes and files key may still upload; a negative value means no limit
+	Reserve(ctx context.Context, key string) (bytes int64, files int, err error)
+	// Commit adds the bytes and files saved by the reserved upload to the usage of key and ends
+	// the reservation. It is called once for every successful Reserve, with zeros if nothing was saved.
+	Commit(key string, bytes int64, files int) error
+}
+
+// QuotaUsage is the amount uploaded under a quota key
+type QuotaUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// batchQuota is the quota remaining when an upload started
+type batchQuota struct {
+	bytes int64
+	files int
+}
+
+// UploadFilesWithQuota uploads files like UploadFiles, counting them against the quota of key in
+// Tools.Quota. Files that would exceed the remaining quota are rejected with an UploadLimitError
+// before or while they are written, and the size and number of the files saved is recorded.
+// Uploads of the same key wait for each other, until the context of r is done.
+func (t *Tools) UploadFilesWithQuota(r *http.Request, uploadDir, key string, rename ...bool) ([]*UploadedFile, error) {
+	if t.Quota == nil {
+		return nil, errors.New("no QuotaProvider is set")
+	}
+
+	renameFile := true
+
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	return t.uploadFilesWithQuota(r, uploadDir, key, renameFile, &uploadBatch{}, nil)
+}
+
+// uploadFilesWithQuota reserves the quota of key for batch, which waits until its context is
+// done, uploads the files and commits what was saved
+func (t *Tools) uploadFilesWithQuota(r *http.Request, uploadDir, key string, renameFile bool, batch *uploadBatch, data interface{}) ([]*UploadedFile, error) {
+	ctx := batch.ctx
+	if ctx == nil {
+		ctx = r.Context()
+	}
+
+	bytes, files, err := t.Quota.Reserve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	batch.quota = &batchQuota{bytes: bytes, files: files}
+	uploadedFiles, err := t.uploadFiles(r, uploadDir, renameFile, batch, data)
+
+	// files kept by a failed upload still count
+	var size int64
+	var count int
+	for _, uploadedFile := range uploadedFiles {
+		if !uploadedFile.Duplicate {
+			size += uploadedFile.FileSize
+			count++
+		}
+	}
+
+	if commitErr := t.Quota.Commit(key, size, count); commitErr != nil && err == nil {
+		err = commitErr
+	}
+
+	return uploadedFiles, err
+}
+
+// remainingQuota returns what is left of a quota of maxBytes and maxFiles after usage, where zero
+// limits mean no limit
+func remainingQuota(maxBytes int64, maxFiles int, usage QuotaUsage) (int64, int) {
+	bytes, files := int64(-1), -1
+
+	if maxBytes > 0 {
+		bytes = max(0, maxBytes-usage.Bytes)
+	}
+
+	if maxFiles > 0 {
+		files = max(0, maxFiles-usage.Files)
+	}
+
+	return bytes, files
+}
+
+// quotaReservations holds the reservations of the keys of a quota, one at a time per key
+type quotaReservations struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// reserve waits until key is not reserved and reserves it, or returns the error of ctx
+func (q *quotaReservations) reserve(ctx context.Context, key string) error {
+	q.mu.Lock()
+	if q.slots == nil {
+		q.slots = make(map[string]chan struct{})
+	}
+	slot, ok := q.slots[key]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		q.slots[key] = slot
+	}
+	q.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release ends the reservation of key, if there is one
+func (q *quotaReservations) release(key string) {
+	q.mu.Lock()
+	slot := q.slots[key]
+	q.mu.Unlock()
+
+	select {
+	case <-slot:
+	default:
+	}
+}
+
+// MemoryQuota is a QuotaProvider that keeps usage in memory and gives every key the same limits.
+// A zero MaxBytes or MaxFiles means no limit.
+type MemoryQuota struct {
+	MaxBytes int64
+	MaxFiles int
+
+	mu           sync.Mutex
+	usage        map[string]QuotaUsage
+	reservations quotaReservations
+}
+
+// Reserve implements QuotaProvider
+func (q *MemoryQuota) Reserve(ctx context.Context, key string) (int64, int, error) {
+	if err := q.reservations.reserve(ctx, key); err != nil {
+		return 0, 0, err
+	}
+
+	bytes, files := remainingQuota(q.MaxBytes, q.MaxFiles, q.Usage(key))
+	return bytes, files, nil
+}
+
+// Commit implements QuotaProvider
+func (q *MemoryQuota) Commit(key string, bytes int64, files int) error {
+	defer q.reservations.release(key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.usage == nil {
+		q.usage = make(map[string]QuotaUsage)
+	}
+
+	usage := q.usage[key]
+	usage.Bytes += bytes
+	usage.Files += files
+	q.usage[key] = usage
+
+	return nil
+}
+
+// Usage returns the recorded usage of key
+func (q *MemoryQuota) Usage(key string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.usage[key]
+}
+
+// FileQuota is a QuotaProvider that keeps usage in a JSON file at Path, so that it survives
+// restarts, and gives every key the same limits. A zero MaxBytes or MaxFiles means no limit.
+// It is safe for concurrent use within one process; uploads of other processes sharing the file
+// are not held back by its reservations.
+type FileQuota struct {
+	Path     string
+	MaxBytes int64
+	MaxFiles int
+
+	mu           sync.Mutex
+	reservations quotaReservations
+}
+
+// Reserve implements QuotaProvider
+func (q *FileQuota) Reserve(ctx context.Context, key string) (int64, int, error) {
+	if err := q.reservations.reserve(ctx, key); err != nil {
+		return 0, 0, err
+	}
+
+	usage, err := q.Usage(key)
+	if err != nil {
+		q.reservations.release(key)
+		return 0, 0, err
+	}
+
+	bytes, files := remainingQuota(q.MaxBytes, q.MaxFiles, usage)
+	return bytes, files, nil
+}
+
+// Commit implements QuotaProvider
+func (q *FileQuota) Commit(key string, bytes int64, files int) error {
+	defer q.reservations.release(key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, err := q.load()
+	if err != nil {
+		return err
+	}
+
+	u := usage[key]
+	u.Bytes += bytes
+	u.Files += files
+	usage[key] = u
+
+	return q.save(usage)
+}
+
+// Usage returns the recorded usage of key
+func (q *FileQuota) Usage(key string) (QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage, err := q.load()
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	return usage[key], nil
+}
+
+// load reads the usage of all keys; a missing file means nothing was recorded yet
+func (q *FileQuota) load() (map[string]QuotaUsage, error) {
+	usage := make(map[string]QuotaUsage)
+
+	data, err := os.ReadFile(q.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// save replaces the usage file, writing a temporary file first so that it is never left half written
+func (q *FileQuota) save(usage map[string]QuotaUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.Path), filepath.Base(q.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), q.Path)
+}
@@ -0,0 +1,180 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var quotaTests = []struct {
+	name          string
+	maxBytes      int64
+	maxFiles      int
+	used          QuotaUsage
+	files         int
+	expectedLimit UploadLimit
+	expectedUsage QuotaUsage
+}{
+	{name: "within quota", maxBytes: 10000, maxFiles: 5, files: 2, expectedUsage: QuotaUsage{Bytes: 2000, Files: 2}},
+	{name: "unlimited", files: 3, expectedUsage: QuotaUsage{Bytes: 3000, Files: 3}},
+	{name: "too many files", maxFiles: 3, used: QuotaUsage{Files: 2}, files: 2, expectedLimit: LimitQuotaFiles, expectedUsage: QuotaUsage{Bytes: 1000, Files: 3}},
+	{name: "too many bytes", maxBytes: 2500, used: QuotaUsage{Bytes: 1000}, files: 2, expectedLimit: LimitQuotaBytes, expectedUsage: QuotaUsage{Bytes: 2000, Files: 1}},
+	{name: "quota used up", maxBytes: 2000, used: QuotaUsage{Bytes: 2000}, files: 1, expectedLimit: LimitQuotaBytes, expectedUsage: QuotaUsage{Bytes: 2000}},
+}
+
+func TestTools_UploadFilesWithQuota(t *testing.T) {
+	for _, entry := range quotaTests {
+		providers := map[string]QuotaProvider{
+			"memory": &MemoryQuota{MaxBytes: entry.maxBytes, MaxFiles: entry.maxFiles},
+			"file":   &FileQuota{Path: filepath.Join(t.TempDir(), "quota.json"), MaxBytes: entry.maxBytes, MaxFiles: entry.maxFiles},
+		}
+
+		for kind, provider := range providers {
+			if _, _, err := provider.Reserve(context.Background(), "user-1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := provider.Commit("user-1", entry.used.Bytes, entry.used.Files); err != nil {
+				t.Fatal(err)
+			}
+
+			var files []testFormFile
+			for i := 0; i < entry.files; i++ {
+				files = append(files, testFormFile{field: "file", fileName: "doc.pdf", content: pdfBytes})
+			}
+
+			testTools := Tools{Quota: provider}
+			uploadDir := t.TempDir()
+			_, err := testTools.UploadFilesWithQuota(newMultipartRequest(t, files...), uploadDir, "user-1")
+
+			if entry.expectedLimit != "" {
+				var limitErr *UploadLimitError
+				if !errors.As(err, &limitErr) || limitErr.Limit != entry.expectedLimit {
+					t.Errorf("%s (%s): expected %s error, got %v", entry.name, kind, entry.expectedLimit, err)
+				}
+			} else if err != nil {
+				t.Errorf("%s (%s): %v", entry.name, kind, err)
+			}
+
+			var usage QuotaUsage
+			switch p := provider.(type) {
+			case *MemoryQuota:
+				usage = p.Usage("user-1")
+			case *FileQuota:
+				usage, err = p.Usage("user-1")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if usage != entry.expectedUsage {
+				t.Errorf("%s (%s): expected usage %+v, got %+v", entry.name, kind, entry.expectedUsage, usage)
+			}
+
+			// the rejected file must not be left behind
+			entries, _ := os.ReadDir(uploadDir)
+			if len(entries) != usage.Files-entry.used.Files {
+				t.Errorf("%s (%s): expected %d files on disk, got %d", entry.name, kind, usage.Files-entry.used.Files, len(entries))
+			}
+		}
+	}
+}
+
+func TestFileQuota_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	quota := &FileQuota{Path: path, MaxBytes: 100}
+	if _, _, err := quota.Reserve(context.Background(), "tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Commit("tenant", 60, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := &FileQuota{Path: path, MaxBytes: 100}
+	bytes, files, err := reopened.Reserve(context.Background(), "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = reopened.Commit("tenant", 0, 0)
+
+	if bytes != 40 || files != -1 {
+		t.Errorf("expected 40 bytes and unlimited files remaining, got %d and %d", bytes, files)
+	}
+}
+
+func TestTools_UploadFilesWithQuotaNoProvider(t *testing.T) {
+	var testTools Tools
+
+	if _, err := testTools.UploadFilesWithQuota(newMultipartRequest(t), t.TempDir(), "user-1"); err == nil {
+		t.Error("expected error without a QuotaProvider")
+	}
+}
+
+func TestTools_UploadFilesWithQuotaConcurrent(t *testing.T) {
+	providers := map[string]QuotaProvider{
+		"memory": &MemoryQuota{MaxBytes: 1500},
+		"file":   &FileQuota{Path: filepath.Join(t.TempDir(), "quota.json"), MaxBytes: 1500},
+	}
+
+	for kind, provider := range providers {
+		testTools := Tools{Quota: provider}
+		uploadDir := t.TempDir()
+
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+		for i := range errs {
+			request := newMultipartRequest(t, testFormFile{field: "file", fileName: "doc.pdf", content: pdfBytes})
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = testTools.UploadFilesWithQuota(request, uploadDir, "user-1")
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			var limitErr *UploadLimitError
+			if err == nil {
+				succeeded++
+			} else if !errors.As(err, &limitErr) || limitErr.Limit != LimitQuotaBytes {
+				t.Errorf("%s: expected a quota error, got %v", kind, err)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Errorf("%s: expected exactly one upload within the quota, got %d", kind, succeeded)
+		}
+	}
+}
+
+func TestMemoryQuota_ReserveWaits(t *testing.T) {
+	quota := &MemoryQuota{MaxFiles: 5}
+
+	if _, _, err := quota.Reserve(context.Background(), "user-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := quota.Reserve(ctx, "user-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a reserved key to wait, got %v", err)
+	}
+
+	if _, _, err := quota.Reserve(context.Background(), "user-2"); err != nil {
+		t.Errorf("expected other keys not to wait, got %v", err)
+	}
+
+	if err := quota.Commit("user-1", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, files, err := quota.Reserve(context.Background(), "user-1")
+	if err != nil || files != 4 {
+		t.Errorf("expected 4 files remaining after commit, got %d and %v", files, err)
+	}
+}
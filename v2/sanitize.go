@@ -0,0 +1,217 @@
+package toolkit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultMaxFileNameLength is the longest file name, in bytes, kept by SanitizeFileName
+// when Tools.MaxFileNameLength is not set
+const defaultMaxFileNameLength = 255
+
+// CollisionStrategy says what happens when an uploaded file would replace an existing file
+type CollisionStrategy int
+
+const (
+	CollisionOverwrite     CollisionStrategy = iota // replace the existing file
+	CollisionReject                                 // fail with a FileExistsError
+	CollisionAppendCounter                          // save as name-1.ext, name-2.ext, ...
+)
+
+// FileExistsError is returned when an uploaded file would replace an existing file
+// and Tools.FileNameCollision is CollisionReject
+type FileExistsError struct {
+	FileName string
+}
+
+// Error implements the error interface
+func (e *FileExistsError) Error() string {
+	return fmt.Sprintf("a file named %q already exists", e.FileName)
+}
+
+// windowsReservedNames are device names that cannot be used as file names on Windows,
+// with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// reservedFileNameSuffixes end the names of the files kept next to uploaded files, which uploads
+// must not replace
+var reservedFileNameSuffixes = []string{sidecarSuffix, expirySuffix}
+
+// isReservedFileName reports whether an uploaded file may not be saved as fileName
+func isReservedFileName(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	for _, suffix := range reservedFileNameSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// latinCompositions lists, for each combining mark, pairs of a base letter followed by the
+// precomposed letter it forms with that mark. It covers the Latin letters of Latin-1, Latin
+// Extended-A and -B and Latin Extended Additional, Vietnamese included, which is enough to turn
+// the decomposed Latin names sent by some systems (notably macOS) into their usual composed form.
+//
+// This is a partial NFC, kept to the standard library. Names in other scripts keep the byte form
+// they were sent in: Greek with tonos or dialytika, Cyrillic such as й and ё, Hangul written as
+// jamo, kana with voiced sound marks, and the Indic, Hebrew and Arabic scripts are not composed.
+// Marks are not put in canonical order either, so only the order NFD produces is composed.
+var latinCompositions = map[rune]string{
+	0x0300: "AÀEÈIÌNǸOÒUÙWẀYỲaàeèiìnǹoòuùwẁyỳÂẦÊỀÔỒÜǛâầêềôồüǜĂẰăằĒḔēḕŌṐōṑƠỜơờƯỪưừ",                                                                 // combining grave accent
+	0x0301: "AÁCĆEÉGǴIÍKḰLĹMḾNŃOÓPṔRŔSŚUÚWẂYÝZŹaácćeégǵiíkḱlĺmḿnńoópṕrŕsśuúwẃyýzźÂẤÅǺÆǼÇḈÊẾÏḮÔỐÕṌØǾÜǗâấåǻæǽçḉêếïḯôốõṍøǿüǘĂẮăắĒḖēḗŌṒōṓŨṸũṹƠỚơớƯỨưứ", // combining acute accent
+	0x0302: "AÂCĈEÊGĜHĤIÎJĴOÔSŜUÛWŴYŶZẐaâcĉeêgĝhĥiîjĵoôsŝuûwŵyŷzẑẠẬạậẸỆẹệỌỘọộ",                                                                     // combining circumflex accent
+	0x0303: "AÃEẼIĨNÑOÕUŨVṼYỸaãeẽiĩnñoõuũvṽyỹÂẪÊỄÔỖâẫêễôỗĂẴăẵƠỠơỡƯỮưữ",                                                                             // combining tilde
+	0x0304: "AĀEĒGḠIĪOŌUŪYȲaāeēgḡiīoōuūyȳÄǞÆǢÕȬÖȪÜǕäǟæǣõȭöȫüǖǪǬǫǭȦǠȧǡȮȰȯȱḶḸḷḹṚṜṛṝ",                                                                 // combining macron
+	0x0306: "AĂEĔGĞIĬOŎUŬaăeĕgğiĭoŏuŭȨḜȩḝẠẶạặ",                                                                                                     // combining breve
+	0x0307: "AȦBḂCĊDḊEĖFḞGĠHḢIİMṀNṄOȮPṖRṘSṠTṪWẆXẊYẎZŻaȧbḃcċdḋeėfḟgġhḣmṁnṅoȯpṗrṙsṡtṫwẇxẋyẏzżŚṤśṥŠṦšṧſẛṢṨṣṩ",                                         // combining dot above
+	0x0308: "AÄEËHḦIÏOÖUÜWẄXẌYŸaäeëhḧiïoötẗuüwẅxẍyÿÕṎõṏŪṺūṻ",                                                                                       // combining diaeresis
+	0x0309: "AẢEẺIỈOỎUỦYỶaảeẻiỉoỏuủyỷÂẨÊỂÔỔâẩêểôổĂẲăẳƠỞơởƯỬưử",                                                                                     // combining hook above
+	0x030A: "AÅUŮaåuůwẘyẙ",                                                                                                                         // combining ring above
+	0x030B: "OŐUŰoőuű",                                                                                                                             // combining double acute accent
+	0x030C: "AǍCČDĎEĚGǦHȞIǏKǨLĽNŇOǑRŘSŠTŤUǓZŽaǎcčdďeěgǧhȟiǐjǰkǩlľnňoǒrřsštťuǔzžÜǙüǚƷǮʒǯ",                                                           // combining caron
+	0x030F: "AȀEȄIȈOȌRȐUȔaȁeȅiȉoȍrȑuȕ",                                                                                                             // combining double grave accent
+	0x0311: "AȂEȆIȊOȎRȒUȖaȃeȇiȋoȏrȓuȗ",                                                                                                             // combining inverted breve
+	0x031B: "OƠUƯoơuư",                                                                                                                             // combining horn
+	0x0323: "AẠBḄDḌEẸHḤIỊKḲLḶMṂNṆOỌRṚSṢTṬUỤVṾWẈYỴZẒaạbḅdḍeẹhḥiịkḳlḷmṃnṇoọrṛsṣtṭuụvṿwẉyỵzẓƠỢơợƯỰưự",                                                 // combining dot below
+	0x0324: "UṲuṳ",                                                                                                                                 // combining diaeresis below
+	0x0325: "AḀaḁ",                                                                                                                                 // combining ring below
+	0x0326: "SȘTȚsștț",                                                                                                                             // combining comma below
+	0x0327: "CÇDḐEȨGĢHḨKĶLĻNŅRŖSŞTŢcçdḑeȩgģhḩkķlļnņrŗsştţ",                                                                                         // combining cedilla
+	0x0328: "AĄEĘIĮOǪUŲaąeęiįoǫuų",                                                                                                                 // combining ogonek
+	0x032D: "DḒEḘLḼNṊTṰUṶdḓeḙlḽnṋtṱuṷ",                                                                                                             // combining circumflex accent below
+	0x032E: "HḪhḫ",                                                                                                                                 // combining breve below
+	0x0330: "EḚIḬUṴeḛiḭuṵ",                                                                                                                         // combining tilde below
+	0x0331: "BḆDḎKḴLḺNṈRṞTṮZẔbḇdḏhẖkḵlḻnṉrṟtṯzẕ",                                                                                                   // combining macron below
+}
+
+// compositions maps a base letter and combining mark to their precomposed letter
+var compositions = func() map[[2]rune]rune {
+	m := make(map[[2]rune]rune)
+	for mark, pairs := range latinCompositions {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			m[[2]rune{runes[i], mark}] = runes[i+1]
+		}
+	}
+	return m
+}()
+
+// composeLatin replaces base letters followed by combining marks with precomposed letters
+func composeLatin(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		if n := len(out); n > 0 && unicode.Is(unicode.Mn, r) {
+			if composed, ok := compositions[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+// SanitizeFileName turns a file name sent by a client into one that is safe to save in the
+// upload directory: any directory part is dropped, decomposed Latin letters are composed,
+// control, formatting and reserved characters are replaced, leading and trailing dots and
+// spaces are trimmed, Windows device names are prefixed and the name is shortened to
+// MaxFileNameLength bytes, keeping its extension. Composition is not full Unicode normalization
+// (see latinCompositions), so in other scripts a decomposed name stays different from its
+// composed form.
+func (t *Tools) SanitizeFileName(name string) string {
+	// clients may send either separator, whatever the server's OS
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = composeLatin(strings.ToValidUTF8(name, "_"))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		name = "unnamed"
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimRight(strings.TrimSuffix(name, ext), ". ")
+	if base == "" {
+		base, ext = strings.TrimPrefix(ext, "."), ""
+	}
+
+	if windowsReservedNames[strings.ToUpper(strings.SplitN(base, ".", 2)[0])] {
+		base = "_" + base
+	}
+
+	maxLength := t.maxFileNameLength()
+	if len(ext) >= maxLength {
+		ext = ""
+	}
+	base = truncateUTF8(base, maxLength-len(ext))
+
+	return base + ext
+}
+
+// maxFileNameLength returns the configured file name length limit, or the default
+func (t *Tools) maxFileNameLength() int {
+	if t.MaxFileNameLength <= 0 {
+		return defaultMaxFileNameLength
+	}
+
+	return t.MaxFileNameLength
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+// resolveCollision applies FileNameCollision to fileName in uploadDir, considering both stored
+// files and files pending in batch, and returns the name to save the file under
+func (t *Tools) resolveCollision(uploadDir, fileName string, batch *uploadBatch) (string, error) {
+	if t.FileNameCollision == CollisionOverwrite || !t.isDuplicate(filepath.Join(uploadDir, fileName), batch) {
+		return fileName, nil
+	}
+
+	if t.FileNameCollision == CollisionReject {
+		return "", &FileExistsError{FileName: fileName}
+	}
+
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for i := 1; ; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		name := truncateUTF8(base, t.maxFileNameLength()-len(suffix)-len(ext)) + suffix + ext
+		if !t.isDuplicate(filepath.Join(uploadDir, name), batch) {
+			return name, nil
+		}
+	}
+}
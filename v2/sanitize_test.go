@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var sanitizeFileNameTests = []struct {
+	name      string
+	input     string
+	maxLength int
+	expected  string
+}{
+	{name: "plain", input: "cyborg-ape.png", expected: "cyborg-ape.png"},
+	{name: "unix path", input: "../../etc/passwd", expected: "passwd"},
+	{name: "windows path", input: `..\..\windows\win.ini`, expected: "win.ini"},
+	{name: "dot dot", input: "..", expected: "unnamed"},
+	{name: "hidden file", input: ".htaccess", expected: "htaccess"},
+	{name: "trailing dots and spaces", input: "report.pdf. . ", expected: "report.pdf"},
+	{name: "control characters", input: "inv\x00oi\nce\x7f.pdf", expected: "invoice.pdf"},
+	{name: "bidi override", input: "harmless\u202egnp.exe", expected: "harmlessgnp.exe"},
+	{name: "reserved characters", input: `what?<is>:"this"|*.txt`, expected: "what__is___this___.txt"},
+	{name: "windows device", input: "CON", expected: "_CON"},
+	{name: "windows device with extension", input: "com1.tar.gz", expected: "_com1.tar.gz"},
+	{name: "not a windows device", input: "console.log", expected: "console.log"},
+	{name: "decomposed", input: "Cafe\u0301 Zu\u0308rich.jpg", expected: "Caf\u00e9 Z\u00fcrich.jpg"},
+	{name: "stacked marks", input: "Vie\u0323\u0302t.png", expected: "Vi\u1ec7t.png"},
+	{name: "decomposed cyrillic kept", input: "\u0438\u0306.txt", expected: "\u0438\u0306.txt"},
+	{name: "decomposed greek kept", input: "\u03b1\u0301.txt", expected: "\u03b1\u0301.txt"},
+	{name: "hangul jamo kept", input: "\u1100\u1161.txt", expected: "\u1100\u1161.txt"},
+	{name: "voiced kana kept", input: "\u304b\u3099.txt", expected: "\u304b\u3099.txt"},
+	{name: "marks out of order kept", input: "e\u0302\u0323.txt", expected: "\u00ea\u0323.txt"},
+	{name: "invalid utf8", input: "bad\xffname.png", expected: "bad_name.png"},
+	{name: "too long", input: strings.Repeat("a", 300) + ".png", expected: strings.Repeat("a", 251) + ".png"},
+	{name: "too long multibyte", input: strings.Repeat("é", 10) + ".png", maxLength: 12, expected: "éééé.png"},
+	{name: "empty", input: "", expected: "unnamed"},
+}
+
+func TestTools_SanitizeFileName(t *testing.T) {
+	for _, entry := range sanitizeFileNameTests {
+		tools := Tools{MaxFileNameLength: entry.maxLength}
+
+		actual := tools.SanitizeFileName(entry.input)
+		if actual != entry.expected {
+			t.Errorf("%s: expected %q, got %q", entry.name, entry.expected, actual)
+		}
+	}
+}
+
+var collisionTests = []struct {
+	name          string
+	strategy      CollisionStrategy
+	expectedNames []string
+	errorExpected bool
+}{
+	{name: "overwrite", strategy: CollisionOverwrite, expectedNames: []string{"uploads/doc.pdf"}},
+	{name: "reject", strategy: CollisionReject, expectedNames: []string{"uploads/doc.pdf"}, errorExpected: true},
+	{name: "append counter", strategy: CollisionAppendCounter, expectedNames: []string{"uploads/doc-1.pdf", "uploads/doc-2.pdf", "uploads/doc.pdf"}},
+}
+
+func TestTools_UploadFilesCollision(t *testing.T) {
+	for _, entry := range collisionTests {
+		store := NewMemoryStorage()
+		_, _ = store.Put("uploads/doc.pdf", strings.NewReader("%PDF-1.4 existing"))
+		testTools := Tools{Storage: store, FileNameCollision: entry.strategy}
+
+		request := newMultipartRequest(t,
+			testFormFile{field: "file", fileName: "doc.pdf", content: []byte("%PDF-1.4 first")},
+			testFormFile{field: "file", fileName: "doc.pdf", content: []byte("%PDF-1.4 second")},
+		)
+
+		_, err := testTools.UploadFiles(request, "uploads", false)
+
+		var existsErr *FileExistsError
+		if entry.errorExpected && !errors.As(err, &existsErr) {
+			t.Errorf("%s: expected FileExistsError, got %v", entry.name, err)
+		}
+
+		if !entry.errorExpected && err != nil {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+		}
+
+		names, _ := store.List("uploads")
+		if strings.Join(names, ",") != strings.Join(entry.expectedNames, ",") {
+			t.Errorf("%s: expected %v, got %v", entry.name, entry.expectedNames, names)
+		}
+	}
+}
+
+func TestTools_UploadFilesSanitizedName(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, StreamUploads: true}
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "..\\..\\evil\u202efdp.pdf", content: []byte("%PDF-1.4")})
+
+	uploadedFile, err := testTools.UploadFile(request, "uploads", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploadedFile.NewFileName != "evilfdp.pdf" {
+		t.Errorf("unexpected file name %q", uploadedFile.NewFileName)
+	}
+
+	if _, err := store.Stat("uploads/evilfdp.pdf"); err != nil {
+		t.Errorf("expected sanitized file to be stored: %v", err)
+	}
+}
+
+func TestTools_UploadFilesReservedName(t *testing.T) {
+	for _, fileName := range []string{"cat.png.meta.json", "cat.png.EXPIRES.json"} {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, AllowedFileTypes: []string{"text/plain"}}
+
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: fileName, content: []byte(`{"expires":"2000-01-01T00:00:00Z"}`)})
+
+		if _, err := testTools.UploadFiles(request, "uploads", false); err == nil {
+			t.Errorf("%s: expected an error for a reserved name", fileName)
+		}
+
+		if _, err := store.Stat("uploads/" + fileName); err == nil {
+			t.Errorf("%s: file with a reserved name was stored", fileName)
+		}
+	}
+}
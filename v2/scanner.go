@@ -0,0 +1,159 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScanResult is the verdict returned by a Scanner
+type ScanResult struct {
+	Clean   bool
+	Verdict string
+}
+
+// Scanner is the interface used to check uploaded files, e.g. with a virus scanner or a
+// content policy, before they are moved into place. Scan is given a reader over the complete
+// file and the information gathered about it so far.
+type Scanner interface {
+	Scan(r io.Reader, uploadedFile UploadedFile) (ScanResult, error)
+}
+
+// ScannerFunc is an adapter to allow the use of ordinary functions as a Scanner
+type ScannerFunc func(r io.Reader, uploadedFile UploadedFile) (ScanResult, error)
+
+// Scan calls f(r, uploadedFile)
+func (f ScannerFunc) Scan(r io.Reader, uploadedFile UploadedFile) (ScanResult, error) {
+	return f(r, uploadedFile)
+}
+
+// ScanError is returned when a Scanner rejects an uploaded file. If Tools.QuarantineDir is set,
+// Quarantined holds the name the file was moved to.
+type ScanError struct {
+	FileName    string
+	Verdict     string
+	Quarantined string
+}
+
+// Error implements the error interface
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("file %q was rejected by the scanner: %s", e.FileName, e.Verdict)
+}
+
+// scanFile runs the configured Scanner over the stored file tempName. A rejected file is moved to
+// QuarantineDir if one is set; otherwise it is left for the caller to delete.
+func (t *Tools) scanFile(tempName string, uploadedFile *UploadedFile) error {
+	file, err := t.storage().Get(tempName)
+	if err != nil {
+		return err
+	}
+
+	result, err := t.Scanner.Scan(file, *uploadedFile)
+	_ = file.Close()
+	if err != nil {
+		return fmt.Errorf("file %q could not be scanned: %w", uploadedFile.OriginalFileName, err)
+	}
+
+	if result.Clean {
+		return nil
+	}
+
+	scanErr := &ScanError{FileName: uploadedFile.OriginalFileName, Verdict: result.Verdict}
+	if t.QuarantineDir != "" {
+		name := filepath.Join(t.QuarantineDir, t.RandomString(25)+filepath.Ext(uploadedFile.NewFileName))
+		if err := t.moveFile(tempName, name); err == nil {
+			scanErr.Quarantined = name
+		}
+	}
+
+	return scanErr
+}
+
+// clamdChunkSize is the size of the chunks streamed to clamd
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner is a Scanner that streams files to a ClamAV daemon using the INSTREAM command.
+// Network and Address are passed to net.Dial, e.g. "tcp" and "localhost:3310", or "unix" and
+// the path to the clamd socket.
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// Scan implements Scanner
+func (c *ClamdScanner) Scan(r io.Reader, uploadedFile UploadedFile) (ScanResult, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	conn, err := net.DialTimeout(c.Network, c.Address, timeout)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return ScanResult{}, err
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ScanResult{}, err
+		}
+	}
+
+	// a zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return ScanResult{}, err
+	}
+
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply converts a clamd reply such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND" into a ScanResult
+func parseClamdReply(reply string) (ScanResult, error) {
+	status := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case status == "OK":
+		return ScanResult{Clean: true, Verdict: status}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return ScanResult{Clean: false, Verdict: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+}
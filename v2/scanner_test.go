@@ -0,0 +1,179 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// eicar is the standard antivirus test string
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// startFakeClamd starts a minimal clamd stand-in that reports any stream containing eicar as infected
+func startFakeClamd(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+
+				command, err := r.ReadBytes(0)
+				if err != nil || string(command) != "zINSTREAM\x00" {
+					_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var data bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+						return
+					}
+				}
+
+				if bytes.Contains(data.Bytes(), []byte(eicar)) {
+					_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					return
+				}
+				_, _ = conn.Write([]byte("stream: OK\x00"))
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+var scannerTests = []struct {
+	name            string
+	fileName        string
+	content         []byte
+	quarantine      bool
+	expectedVerdict string
+}{
+	{name: "clean", fileName: "doc.pdf", content: []byte("%PDF-1.4 clean")},
+	{name: "infected", fileName: "doc.pdf", content: []byte("%PDF-1.4 " + eicar), expectedVerdict: "Eicar-Test-Signature"},
+	{name: "infected quarantined", fileName: "doc.pdf", content: []byte("%PDF-1.4 " + eicar), quarantine: true, expectedVerdict: "Eicar-Test-Signature"},
+	{name: "quarantined unsafe name", fileName: "doc.p\u202edf", content: []byte("%PDF-1.4 " + eicar), quarantine: true, expectedVerdict: "Eicar-Test-Signature"},
+}
+
+func TestTools_UploadFilesScanner(t *testing.T) {
+	address := startFakeClamd(t)
+
+	for _, entry := range scannerTests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, Scanner: &ClamdScanner{Network: "tcp", Address: address}}
+		if entry.quarantine {
+			testTools.QuarantineDir = "quarantine"
+		}
+
+		_, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: entry.content}), "uploads")
+
+		uploads, _ := store.List("uploads")
+		quarantined, _ := store.List("quarantine")
+
+		if entry.expectedVerdict == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			}
+			if len(uploads) != 1 {
+				t.Errorf("%s: expected file to be stored, found %v", entry.name, uploads)
+			}
+			continue
+		}
+
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) {
+			t.Errorf("%s: expected ScanError, got %v", entry.name, err)
+			continue
+		}
+
+		if scanErr.Verdict != entry.expectedVerdict {
+			t.Errorf("%s: expected verdict %s, got %s", entry.name, entry.expectedVerdict, scanErr.Verdict)
+		}
+
+		if len(uploads) != 0 {
+			t.Errorf("%s: expected no files in upload directory, found %v", entry.name, uploads)
+		}
+
+		if entry.quarantine && (len(quarantined) != 1 || quarantined[0] != scanErr.Quarantined) {
+			t.Errorf("%s: expected file to be quarantined as %s, found %v", entry.name, scanErr.Quarantined, quarantined)
+		}
+
+		// quarantined files are named like stored ones, from the sanitized name
+		if entry.quarantine && filepath.Ext(scanErr.Quarantined) != ".pdf" {
+			t.Errorf("%s: expected quarantined name with extension .pdf, got %s", entry.name, scanErr.Quarantined)
+		}
+
+		if !entry.quarantine && len(quarantined) != 0 {
+			t.Errorf("%s: expected no quarantined files, found %v", entry.name, quarantined)
+		}
+	}
+}
+
+func TestTools_UploadFilesScannerFailure(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{
+		Storage: store,
+		Scanner: ScannerFunc(func(r io.Reader, uploadedFile UploadedFile) (ScanResult, error) {
+			return ScanResult{}, errors.New("scanner unavailable")
+		}),
+	}
+
+	_, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "doc.pdf", content: []byte("%PDF-1.4")}), "uploads")
+	if err == nil {
+		t.Error("expected error when the scanner fails")
+	}
+
+	if names, _ := store.List("uploads"); len(names) != 0 {
+		t.Errorf("expected unscanned file to be removed, found %v", names)
+	}
+}
+
+var clamdReplyTests = []struct {
+	reply           string
+	expectedClean   bool
+	expectedVerdict string
+	errorExpected   bool
+}{
+	{reply: "stream: OK", expectedClean: true, expectedVerdict: "OK"},
+	{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", expectedClean: false, expectedVerdict: "Win.Test.EICAR_HDB-1"},
+	{reply: "INSTREAM size limit exceeded. ERROR", errorExpected: true},
+}
+
+func TestParseClamdReply(t *testing.T) {
+	for _, entry := range clamdReplyTests {
+		result, err := parseClamdReply(entry.reply)
+		if (err != nil) != entry.errorExpected {
+			t.Errorf("%s: unexpected error result: %v", entry.reply, err)
+		}
+
+		if result.Clean != entry.expectedClean || result.Verdict != entry.expectedVerdict {
+			t.Errorf("%s: unexpected result %+v", entry.reply, result)
+		}
+	}
+}
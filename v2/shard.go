@@ -0,0 +1,31 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"time"
+)
+
+// ShardLayout says how renamed uploads are spread over subdirectories of the upload directory
+type ShardLayout int
+
+const (
+	ShardNone   ShardLayout = iota // all files directly in the upload directory
+	ShardByHash                    // two levels named after the hash of the file name, e.g. ab/cd/<name>
+	ShardByDate                    // the upload date in UTC, e.g. 2026/10/16/<name>
+)
+
+// shardDir returns the subdirectory, relative to the upload directory, for a file named fileName
+func (t *Tools) shardDir(fileName string) string {
+	switch t.ShardLayout {
+	case ShardByHash:
+		sum := sha256.Sum256([]byte(fileName))
+		h := hex.EncodeToString(sum[:2])
+		return filepath.Join(h[:2], h[2:4])
+	case ShardByDate:
+		return filepath.FromSlash(time.Now().UTC().Format("2006/01/02"))
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,81 @@
+package toolkit
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var shardTests = []struct {
+	name         string
+	layout       ShardLayout
+	renameFile   bool
+	expectedPath *regexp.Regexp
+}{
+	{name: "none", layout: ShardNone, renameFile: true, expectedPath: regexp.MustCompile(`^[^/]+\.png$`)},
+	{name: "hash", layout: ShardByHash, renameFile: true, expectedPath: regexp.MustCompile(`^[0-9a-f]{2}/[0-9a-f]{2}/[^/]+\.png$`)},
+	{name: "date", layout: ShardByDate, renameFile: true, expectedPath: regexp.MustCompile(`^` + time.Now().UTC().Format("2006/01/02") + `/[^/]+\.png$`)},
+	{name: "original name", layout: ShardByHash, renameFile: false, expectedPath: regexp.MustCompile(`^ape\.png$`)},
+}
+
+func TestTools_UploadFilesShardLayout(t *testing.T) {
+	for _, entry := range shardTests {
+		uploadDir := t.TempDir()
+		testTools := Tools{ShardLayout: entry.layout, Derivatives: []Derivative{{Name: "thumb", MaxSize: 16}}}
+
+		request := newMultipartRequest(t, testFormFile{field: "file", fileName: "ape.png", content: readTestFile(t, "cyborg-ape.png")})
+
+		uploadedFile, err := testTools.UploadFile(request, uploadDir, entry.renameFile)
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+
+		if !entry.expectedPath.MatchString(uploadedFile.Path) {
+			t.Errorf("%s: unexpected path %s", entry.name, uploadedFile.Path)
+		}
+
+		if path.Base(uploadedFile.Path) != uploadedFile.NewFileName {
+			t.Errorf("%s: path %s does not end in %s", entry.name, uploadedFile.Path, uploadedFile.NewFileName)
+		}
+
+		if _, err := os.Stat(filepath.Join(uploadDir, filepath.FromSlash(uploadedFile.Path))); err != nil {
+			t.Errorf("%s: expected file at %s: %v", entry.name, uploadedFile.Path, err)
+		}
+
+		derivative := uploadedFile.Derivatives[0]
+		if path.Dir(derivative.Path) != path.Dir(uploadedFile.Path) {
+			t.Errorf("%s: expected derivative next to original, got %s", entry.name, derivative.Path)
+		}
+
+		if _, err := os.Stat(filepath.Join(uploadDir, filepath.FromSlash(derivative.Path))); err != nil {
+			t.Errorf("%s: expected derivative at %s: %v", entry.name, derivative.Path, err)
+		}
+	}
+}
+
+func TestTools_UploadFilesShardContentAddressed(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, ShardLayout: ShardByHash, ContentAddressed: true}
+
+	content := []byte("%PDF-1.4 sharded")
+	first, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "a.pdf", content: content}), "uploads", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: "b.pdf", content: content}), "uploads", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !second.Duplicate || second.Path != first.Path {
+		t.Errorf("expected second upload to reuse %s, got %s (duplicate %t)", first.Path, second.Path, second.Duplicate)
+	}
+
+	if _, err := store.Stat("uploads/" + first.Path); err != nil {
+		t.Errorf("expected file at %s: %v", first.Path, err)
+	}
+}
@@ -0,0 +1,173 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// minSigningKeySize is the shortest SigningKey accepted for upload tokens
+const minSigningKeySize = 16
+
+var (
+	errInvalidUploadToken = errors.New("invalid upload token")
+	errUploadTokenExpired = errors.New("upload token has expired")
+)
+
+// UploadPolicy is what a signed upload token allows: uploads to UploadDir until Expires. A policy
+// can only narrow the settings of Tools: MaxFileSize lowers the per-file limit, and files must
+// match AllowedFileTypes as well as the types allowed for their form field.
+type UploadPolicy struct {
+	UploadDir        string    `json:"dir"`
+	MaxFileSize      int64     `json:"max_size,omitempty"`
+	AllowedFileTypes []string  `json:"types,omitempty"`
+	Expires          time.Time `json:"exp"`
+}
+
+// SignUploadToken returns a token for policy, signed with SigningKey using HMAC-SHA256. The token
+// is not encrypted, so the policy can be read by whoever holds it, and it is not used up: it
+// allows any number of uploads until it expires, so keep Expires short.
+func (t *Tools) SignUploadToken(policy UploadPolicy) (string, error) {
+	if len(t.SigningKey) < minSigningKeySize {
+		return "", errors.New("SigningKey must be at least 16 bytes")
+	}
+
+	payload, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + t.uploadTokenSignature(encoded), nil
+}
+
+// SignUploadURL returns uploadURL with a signed token for policy added as the "token" query parameter
+func (t *Tools) SignUploadURL(uploadURL string, policy UploadPolicy) (string, error) {
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := t.SignUploadToken(policy)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// VerifyUploadToken checks the signature and expiry of a token made by SignUploadToken and
+// returns its policy
+func (t *Tools) VerifyUploadToken(token string) (UploadPolicy, error) {
+	var policy UploadPolicy
+
+	if len(t.SigningKey) < minSigningKeySize {
+		return policy, errors.New("SigningKey must be at least 16 bytes")
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.uploadTokenSignature(encoded))) {
+		return policy, errInvalidUploadToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return policy, errInvalidUploadToken
+	}
+	if err := json.Unmarshal(payload, &policy); err != nil {
+		return policy, errInvalidUploadToken
+	}
+
+	if !time.Now().Before(policy.Expires) {
+		return policy, errUploadTokenExpired
+	}
+
+	return policy, nil
+}
+
+// uploadTokenSignature returns the encoded HMAC of the encoded policy of a token
+func (t *Tools) uploadTokenSignature(encoded string) string {
+	mac := hmac.New(sha256.New, t.SigningKey)
+	mac.Write([]byte(encoded))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedUploadHandler is an http.Handler that accepts multipart POST requests carrying a token
+// made by SignUploadToken, in the "token" query parameter or an "Upload-Token" header, and saves
+// the files as UploadFiles does under the limits of the token's policy. A token is accepted for
+// every request until it expires.
+type SignedUploadHandler struct {
+	Tools      *Tools
+	RenameFile bool // give saved files a random name, as UploadFiles does by default
+
+	// Completed, if set, writes the response for a successful upload; by default the saved
+	// files are sent as JSON with status 201
+	Completed func(w http.ResponseWriter, r *http.Request, uploadedFiles []*UploadedFile)
+}
+
+// NewSignedUploadHandler returns a SignedUploadHandler that gives saved files random names
+func (t *Tools) NewSignedUploadHandler() *SignedUploadHandler {
+	return &SignedUploadHandler{Tools: t, RenameFile: true}
+}
+
+// ServeHTTP implements http.Handler
+func (h *SignedUploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		_ = h.Tools.ErrorJSON(w, errors.New(http.StatusText(http.StatusMethodNotAllowed)), http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = r.Header.Get("Upload-Token")
+	}
+
+	policy, err := h.Tools.VerifyUploadToken(token)
+	if err != nil {
+		_ = h.Tools.ErrorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	// the policy narrows the settings of a copy, so concurrent requests do not interfere
+	tools := *h.Tools
+	if policy.MaxFileSize > 0 && policy.MaxFileSize < tools.maxFileSize() {
+		tools.MaxFileSize = policy.MaxFileSize
+	}
+	if len(policy.AllowedFileTypes) > 0 {
+		tools.policyFileTypes = policy.AllowedFileTypes
+	}
+
+	uploadedFiles, err := tools.UploadFiles(r, policy.UploadDir, h.RenameFile)
+	if err != nil {
+		var limitErr *UploadLimitError
+		var typeErr *FileTypeError
+		switch {
+		case errors.As(err, &limitErr):
+			_ = h.Tools.ErrorJSON(w, err, http.StatusRequestEntityTooLarge)
+		case errors.As(err, &typeErr):
+			_ = h.Tools.ErrorJSON(w, err, http.StatusUnsupportedMediaType)
+		default:
+			_ = h.Tools.ErrorJSON(w, err)
+		}
+		return
+	}
+
+	if h.Completed != nil {
+		h.Completed(w, r, uploadedFiles)
+		return
+	}
+
+	_ = h.Tools.WriteJSON(w, http.StatusCreated, uploadedFiles)
+}
@@ -0,0 +1,221 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSigningKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestTools_SignUploadToken(t *testing.T) {
+	testTools := Tools{SigningKey: testSigningKey}
+	policy := UploadPolicy{
+		UploadDir:        "uploads/avatars",
+		MaxFileSize:      1024,
+		AllowedFileTypes: []string{"image/png"},
+		Expires:          time.Now().Add(time.Hour),
+	}
+
+	token, err := testTools.SignUploadToken(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, signature, _ := strings.Cut(token, ".")
+	otherTools := Tools{SigningKey: []byte("fedcba9876543210fedcba9876543210")}
+	expired := policy
+	expired.Expires = time.Now().Add(-time.Minute)
+	expiredToken, _ := testTools.SignUploadToken(expired)
+	tampered, _ := testTools.SignUploadToken(UploadPolicy{UploadDir: "uploads", Expires: policy.Expires})
+	tamperedEncoded, _, _ := strings.Cut(tampered, ".")
+
+	var tests = []struct {
+		name          string
+		tools         Tools
+		token         string
+		expectedError error
+	}{
+		{name: "valid", tools: testTools, token: token},
+		{name: "expired", tools: testTools, token: expiredToken, expectedError: errUploadTokenExpired},
+		{name: "other key", tools: otherTools, token: token, expectedError: errInvalidUploadToken},
+		{name: "changed policy", tools: testTools, token: tamperedEncoded + "." + signature, expectedError: errInvalidUploadToken},
+		{name: "no signature", tools: testTools, token: encoded, expectedError: errInvalidUploadToken},
+		{name: "empty", tools: testTools, token: "", expectedError: errInvalidUploadToken},
+	}
+
+	for _, e := range tests {
+		verified, err := e.tools.VerifyUploadToken(e.token)
+		if !errors.Is(err, e.expectedError) {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.expectedError, err)
+			continue
+		}
+
+		if err == nil && (verified.UploadDir != policy.UploadDir || verified.MaxFileSize != policy.MaxFileSize || !verified.Expires.Equal(policy.Expires)) {
+			t.Errorf("%s: unexpected policy %+v", e.name, verified)
+		}
+	}
+
+	shortKey := Tools{SigningKey: []byte("short")}
+	if _, err := shortKey.SignUploadToken(policy); err == nil {
+		t.Error("expected an error for a short signing key")
+	}
+}
+
+func TestTools_SignUploadURL(t *testing.T) {
+	testTools := Tools{SigningKey: testSigningKey}
+
+	signedURL, err := testTools.SignUploadURL("https://uploads.example.com/upload?v=1", UploadPolicy{UploadDir: "uploads", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("v") != "1" {
+		t.Errorf("expected existing query to be kept, got %s", signedURL)
+	}
+	if _, err := testTools.VerifyUploadToken(u.Query().Get("token")); err != nil {
+		t.Errorf("expected a valid token, got %v", err)
+	}
+}
+
+func TestSignedUploadHandler(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, SigningKey: testSigningKey}
+	handler := testTools.NewSignedUploadHandler()
+	handler.RenameFile = false
+
+	sign := func(policy UploadPolicy) string {
+		if policy.Expires.IsZero() {
+			policy.Expires = time.Now().Add(time.Hour)
+		}
+		token, err := testTools.SignUploadToken(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	png := readTestFile(t, "cyborg-ape.png")
+
+	var tests = []struct {
+		name           string
+		method         string
+		token          string
+		header         bool
+		file           testFormFile
+		expectedStatus int
+		expectedFile   string
+	}{
+		{name: "query token", method: http.MethodPost, token: sign(UploadPolicy{UploadDir: "a"}), file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusCreated, expectedFile: "a/ape.png"},
+		{name: "header token", method: http.MethodPost, token: sign(UploadPolicy{UploadDir: "b"}), header: true, file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusCreated, expectedFile: "b/ape.png"},
+		{name: "no token", method: http.MethodPost, file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusForbidden},
+		{name: "expired", method: http.MethodPost, token: sign(UploadPolicy{UploadDir: "c", Expires: time.Now().Add(-time.Second)}), file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusForbidden},
+		{name: "too large", method: http.MethodPost, token: sign(UploadPolicy{UploadDir: "d", MaxFileSize: 100}), file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "wrong type", method: http.MethodPost, token: sign(UploadPolicy{UploadDir: "e", AllowedFileTypes: []string{"image/jpeg"}}), file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "wrong method", method: http.MethodGet, token: sign(UploadPolicy{UploadDir: "f"}), expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, e := range tests {
+		request := newMultipartRequest(t, e.file)
+		request.Method = e.method
+		if e.header {
+			request.Header.Set("Upload-Token", e.token)
+		} else if e.token != "" {
+			request.URL.RawQuery = url.Values{"token": {e.token}}.Encode()
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, request)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+		}
+
+		if e.expectedFile != "" {
+			if _, err := store.Stat(e.expectedFile); err != nil {
+				t.Errorf("%s: expected %s to be saved: %v", e.name, e.expectedFile, err)
+			}
+		}
+	}
+
+	if testTools.MaxFileSize != 0 || testTools.AllowedFileTypes != nil || testTools.policyFileTypes != nil {
+		t.Error("expected the handler not to change the settings of Tools")
+	}
+}
+
+func TestSignedUploadHandlerFileTypes(t *testing.T) {
+	testTools := Tools{
+		Storage:          NewMemoryStorage(),
+		SigningKey:       testSigningKey,
+		AllowedFileTypes: []string{"image/png"},
+		FieldRules:       map[string]FieldRule{"file": {}, "avatar": {AllowedFileTypes: []string{"image/*"}}},
+	}
+	handler := testTools.NewSignedUploadHandler()
+
+	sign := func(allowedTypes ...string) string {
+		token, err := testTools.SignUploadToken(UploadPolicy{UploadDir: "uploads", AllowedFileTypes: allowedTypes, Expires: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	png := readTestFile(t, "cyborg-ape.png")
+	jpg := readTestFile(t, "tipfinger.jpg")
+
+	var tests = []struct {
+		name           string
+		token          string
+		file           testFormFile
+		expectedStatus int
+	}{
+		{name: "within both", token: sign("image/*", "application/pdf"), file: testFormFile{"file", "ape.png", png}, expectedStatus: http.StatusCreated},
+		{name: "widened type", token: sign("image/*", "application/pdf"), file: testFormFile{"file", "doc.pdf", pdfBytes}, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "widened wildcard", token: sign("*/*"), file: testFormFile{"file", "finger.jpg", jpg}, expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "field rule", token: sign("image/jpeg"), file: testFormFile{"avatar", "finger.jpg", jpg}, expectedStatus: http.StatusCreated},
+		{name: "narrowed field rule", token: sign("image/jpeg"), file: testFormFile{"avatar", "ape.png", png}, expectedStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, e := range tests {
+		request := newMultipartRequest(t, e.file)
+		request.URL.RawQuery = url.Values{"token": {e.token}}.Encode()
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, request)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSignedUploadHandlerReuse(t *testing.T) {
+	testTools := Tools{Storage: NewMemoryStorage(), SigningKey: testSigningKey}
+	handler := testTools.NewSignedUploadHandler()
+
+	token, err := testTools.SignUploadToken(UploadPolicy{UploadDir: "uploads", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens are not used up, so every upload before the expiry is accepted
+	for i := 0; i < 3; i++ {
+		request := newMultipartRequest(t, testFormFile{"file", "doc.pdf", pdfBytes})
+		request.URL.RawQuery = url.Values{"token": {token}}.Encode()
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, request)
+
+		if rr.Code != http.StatusCreated {
+			t.Errorf("upload %d: expected status %d, got %d", i, http.StatusCreated, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is the interface used by Tools to save, read and remove uploaded files.
+// Names are the paths built by the upload functions, e.g. filepath.Join(uploadDir, fileName).
+// Implementations must return an error matching fs.ErrNotExist from Get, Stat and Delete
+// when a name does not exist.
+type Storage interface {
+	Put(name string, r io.Reader) (int64, error)
+	Get(name string) (io.ReadCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+	Delete(name string) error
+	List(prefix string) ([]string, error)
+}
+
+// Renamer is implemented by storage backends that can move a file to a new name in a single step.
+// Backends without it are handled by copying the file and deleting the original.
+type Renamer interface {
+	Rename(oldName, newName string) error
+}
+
+// storage returns the configured Storage, or the local filesystem if none is set
+func (t *Tools) storage() Storage {
+	if t.Storage == nil {
+		return &FileSystemStorage{}
+	}
+
+	return t.Storage
+}
+
+// moveFile renames a stored file, falling back to copy and delete when the backend is not a Renamer
+func (t *Tools) moveFile(oldName, newName string) error {
+	store := t.storage()
+	if renamer, ok := store.(Renamer); ok {
+		return renamer.Rename(oldName, newName)
+	}
+
+	file, err := store.Get(oldName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := store.Put(newName, file); err != nil {
+		_ = store.Delete(newName)
+		return err
+	}
+
+	return store.Delete(oldName)
+}
+
+// FileSystemStorage is a Storage that keeps files on the local filesystem.
+// Names are resolved relative to Root; an empty Root uses names as they are.
+type FileSystemStorage struct {
+	Root string
+}
+
+// path converts a storage name to a path on disk
+func (s *FileSystemStorage) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(name))
+}
+
+// Put writes everything read from r to the named file, creating parent directories as needed
+func (s *FileSystemStorage) Put(name string, r io.Reader) (int64, error) {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	outfile, err := os.Create(p)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(outfile, r)
+	if err != nil {
+		_ = outfile.Close()
+		return n, err
+	}
+
+	return n, outfile.Close()
+}
+
+// Get opens the named file for reading
+func (s *FileSystemStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+// Stat returns information about the named file
+func (s *FileSystemStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(s.path(name))
+}
+
+// Delete removes the named file
+func (s *FileSystemStorage) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
+
+// Rename moves the file oldName to newName, creating parent directories as needed
+func (s *FileSystemStorage) Rename(oldName, newName string) error {
+	p := s.path(newName)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Rename(s.path(oldName), p)
+}
+
+// List returns the names of all files below the prefix directory, in lexical order
+func (s *FileSystemStorage) List(prefix string) ([]string, error) {
+	var names []string
+
+	root := s.path(prefix)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == root {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if s.Root == "" {
+			names = append(names, p)
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, rel)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// MemoryStorage is a Storage that keeps files in memory. It is safe for concurrent use
+// and is mostly useful in tests.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFile
+}
+
+// memoryFile is a single file held by MemoryStorage
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]*memoryFile)}
+}
+
+// cleanName normalises a storage name so that equivalent paths share a key
+func (s *MemoryStorage) cleanName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// Put stores everything read from r under name
+func (s *MemoryStorage) Put(name string, r io.Reader) (int64, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r)
+	if err != nil {
+		return n, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files == nil {
+		s.files = make(map[string]*memoryFile)
+	}
+	s.files[s.cleanName(name)] = &memoryFile{data: buf.Bytes(), modTime: time.Now()}
+
+	return n, nil
+}
+
+// Get returns a reader over the named file. The returned reader also implements io.Seeker.
+func (s *MemoryStorage) Get(name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[s.cleanName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return memoryReader{bytes.NewReader(f.data)}, nil
+}
+
+// Stat returns information about the named file
+func (s *MemoryStorage) Stat(name string) (fs.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := s.cleanName(name)
+	f, ok := s.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return memoryFileInfo{name: path.Base(key), size: int64(len(f.data)), modTime: f.modTime}, nil
+}
+
+// Delete removes the named file
+func (s *MemoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.cleanName(name)
+	if _, ok := s.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, key)
+
+	return nil
+}
+
+// Rename moves the file oldName to newName
+func (s *MemoryStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldKey := s.cleanName(oldName)
+	f, ok := s.files[oldKey]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(s.files, oldKey)
+	s.files[s.cleanName(newName)] = f
+
+	return nil
+}
+
+// List returns the names of all files below the prefix directory, in lexical order
+func (s *MemoryStorage) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dir := s.cleanName(prefix)
+	var names []string
+	for name := range s.files {
+		if dir == "." || strings.HasPrefix(name, dir+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// memoryReader adds a no-op Close to a bytes.Reader
+type memoryReader struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (memoryReader) Close() error {
+	return nil
+}
+
+// memoryFileInfo implements fs.FileInfo for files held by MemoryStorage
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memoryFileInfo) Name() string       { return fi.name }
+func (fi memoryFileInfo) Size() int64        { return fi.size }
+func (fi memoryFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (fi memoryFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memoryFileInfo) IsDir() bool        { return false }
+func (fi memoryFileInfo) Sys() interface{}   { return nil }
+
+// readerAt returns random access to a file of the given size opened from storage. If the file
+// does not provide it, src, which reads the whole file, is copied to a local temporary file; the
+// returned function removes it again.
+func readerAt(file io.Reader, src io.Reader, size int64) (io.ReaderAt, int64, func(), error) {
+	if r, ok := file.(io.ReaderAt); ok {
+		return r, size, func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "toolkit-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+
+	size, err = io.Copy(tmp, src)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+
+	return tmp, size, cleanup, nil
+}
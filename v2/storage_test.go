@@ -0,0 +1,119 @@
+package toolkit
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStorage(t *testing.T, name string, store Storage) {
+	t.Helper()
+
+	n, err := store.Put("uploads/a/one.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("%s: put failed: %v", name, err)
+	}
+	if n != 5 {
+		t.Errorf("%s: expected 5 bytes written, got %d", name, n)
+	}
+	_, _ = store.Put("uploads/two.txt", strings.NewReader("world!"))
+	_, _ = store.Put("other/three.txt", strings.NewReader("x"))
+
+	info, err := store.Stat("uploads/a/one.txt")
+	if err != nil {
+		t.Fatalf("%s: stat failed: %v", name, err)
+	}
+	if info.Size() != 5 || info.Name() != "one.txt" {
+		t.Errorf("%s: unexpected file info %s/%d", name, info.Name(), info.Size())
+	}
+
+	rc, err := store.Get("uploads/a/one.txt")
+	if err != nil {
+		t.Fatalf("%s: get failed: %v", name, err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("%s: expected hello, got %s", name, data)
+	}
+
+	names, err := store.List("uploads")
+	if err != nil {
+		t.Fatalf("%s: list failed: %v", name, err)
+	}
+	if len(names) != 2 || filepath.ToSlash(names[0]) != "uploads/a/one.txt" || filepath.ToSlash(names[1]) != "uploads/two.txt" {
+		t.Errorf("%s: unexpected list result %v", name, names)
+	}
+
+	if err := store.Delete("uploads/a/one.txt"); err != nil {
+		t.Errorf("%s: delete failed: %v", name, err)
+	}
+	if _, err := store.Stat("uploads/a/one.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s: expected not exist error after delete, got %v", name, err)
+	}
+	if _, err := store.Get("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s: expected not exist error for missing file, got %v", name, err)
+	}
+}
+
+func TestFileSystemStorage(t *testing.T) {
+	testStorage(t, "filesystem", &FileSystemStorage{Root: t.TempDir()})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, "memory", NewMemoryStorage())
+}
+
+func TestTools_UploadFilesStorage(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "cyborg-ape.png", content: readTestFile(t, "cyborg-ape.png")})
+
+	uploadedFiles, err := testTools.UploadFiles(request, "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := store.Stat("uploads/" + uploadedFiles[0].NewFileName)
+	if err != nil {
+		t.Fatalf("expected file in storage: %v", err)
+	}
+	if info.Size() != uploadedFiles[0].FileSize {
+		t.Errorf("expected stored size %d, got %d", uploadedFiles[0].FileSize, info.Size())
+	}
+
+	if _, err := os.Stat("./uploads"); !os.IsNotExist(err) {
+		t.Error("expected no local upload directory to be created")
+		_ = os.RemoveAll("./uploads")
+	}
+}
+
+func TestTools_DownloadStaticFileStorage(t *testing.T) {
+	store := NewMemoryStorage()
+	_, _ = store.Put("files/report.pdf", strings.NewReader("%PDF-1.4 test"))
+	testTools := Tools{Storage: store}
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	testTools.DownloadStaticFile(rr, req, "files/report.pdf", "report.pdf")
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+	if rr.Body.String() != "%PDF-1.4 test" {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	testTools.DownloadStaticFile(rr, req, "files/missing.pdf", "missing.pdf")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,336 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// pngMetadataChunks are the PNG chunks removed by StripMetadata
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// keepJPEGSegment reports whether a JPEG marker segment survives StripMetadata. Application
+// segments are dropped except JFIF (APP0), ICC profiles (APP2) and Adobe color info (APP14),
+// which are needed to display the image correctly; comments are always dropped. EXIF (APP1)
+// segments are replaced by exifOrientationSegment instead.
+func keepJPEGSegment(marker byte) bool {
+	switch {
+	case marker == 0xFE:
+		return false
+	case marker >= 0xE0 && marker <= 0xEF:
+		return marker == 0xE0 || marker == 0xE2 || marker == 0xEE
+	default:
+		return true
+	}
+}
+
+// exifOrientationSegment returns an APP1 segment holding only the orientation tag of the EXIF
+// segment data, so that stripped photos are still displayed upright, or nil if data is not EXIF
+// or leaves the image unrotated
+func exifOrientationSegment(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+		return nil
+	}
+	tiff := data[6:]
+	if len(tiff) < 8 {
+		return nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	// walk the entries of IFD0 for an orientation of type SHORT
+	offset := int64(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > int64(len(tiff)) {
+		return nil
+	}
+	var orientation uint16
+	for i, count := int64(0), int64(order.Uint16(tiff[offset:])); i < count; i++ {
+		entry := offset + 2 + 12*i
+		if entry+12 > int64(len(tiff)) {
+			return nil
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			orientation = order.Uint16(tiff[entry+8:])
+			break
+		}
+	}
+	if orientation < 2 || orientation > 8 {
+		return nil
+	}
+
+	// marker, length, EXIF header, TIFF header and an IFD0 with a single entry
+	segment := make([]byte, 36)
+	copy(segment, []byte{0xFF, 0xE1, 0x00, 34})
+	copy(segment[4:], "Exif\x00\x00")
+	copy(segment[10:], tiff[:2])
+	order.PutUint16(segment[12:], 42)
+	order.PutUint32(segment[14:], 8)
+	order.PutUint16(segment[18:], 1)
+	order.PutUint16(segment[20:], 0x0112)
+	order.PutUint16(segment[22:], 3)
+	order.PutUint32(segment[24:], 1)
+	order.PutUint16(segment[28:], orientation)
+
+	return segment
+}
+
+// stripMetadata rewrites the stored file tempName without its metadata and returns the name
+// of the rewritten temporary file
+func (t *Tools) stripMetadata(tempName, uploadDir string, uploadedFile *UploadedFile) (string, error) {
+	var strip func(io.Writer, *bufio.Reader) (int64, error)
+
+	switch uploadedFile.ContentType {
+	case "image/jpeg":
+		strip = stripJPEG
+	case "image/png":
+		strip = stripPNG
+	default:
+		return tempName, nil
+	}
+
+	var removed int64
+	newName, err := t.rewriteFile(tempName, uploadDir, uploadedFile, func(w io.Writer, r io.Reader) error {
+		var err error
+		removed, err = strip(w, bufio.NewReader(r))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("metadata could not be removed from file %q: %w", uploadedFile.OriginalFileName, err)
+	}
+	uploadedFile.MetadataBytesRemoved = removed
+
+	return newName, nil
+}
+
+// rewriteFile streams the stored file tempName through rewrite into a new temporary file,
+// deletes the old one and updates the size and digests of uploadedFile
+func (t *Tools) rewriteFile(tempName, uploadDir string, uploadedFile *UploadedFile, rewrite func(io.Writer, io.Reader) error) (string, error) {
+	file, err := t.storage().Get(tempName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digests, err := t.newDigester()
+	if err != nil {
+		return "", err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(rewrite(pw, file))
+	}()
+
+	newName := filepath.Join(uploadDir, tempFilePrefix+t.RandomString(16)+tempFileSuffix)
+	fileSize, err := t.storage().Put(newName, io.TeeReader(pr, digests))
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		_ = t.storage().Delete(newName)
+		return "", err
+	}
+
+	_ = t.storage().Delete(tempName)
+	uploadedFile.FileSize = fileSize
+	digests.record(uploadedFile)
+
+	return newName, nil
+}
+
+// stripJPEG copies a JPEG from r to w without its metadata segments and returns the number of
+// bytes removed. Segments between and after the scans are stripped too, and anything following
+// the end of image marker is dropped.
+func stripJPEG(w io.Writer, r *bufio.Reader) (int64, error) {
+	var removed int64
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return 0, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return 0, errors.New("missing JPEG start of image marker")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(soi)
+
+	// marker holds the next marker once a scan has been copied, as the scan ends by reading it
+	var marker byte
+	for {
+		if marker == 0 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if b != 0xFF {
+				return 0, fmt.Errorf("expected JPEG marker, found 0x%02x", b)
+			}
+
+			// skip fill bytes
+			marker = 0xFF
+			for marker == 0xFF {
+				if marker, err = r.ReadByte(); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		// markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			bw.Write([]byte{0xFF, marker})
+			marker = 0
+			continue
+		}
+		if marker == 0xD9 {
+			bw.Write([]byte{0xFF, marker})
+			trailing, err := io.Copy(io.Discard, r)
+			if err != nil {
+				return 0, err
+			}
+			return removed + trailing, bw.Flush()
+		}
+
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		length := int64(binary.BigEndian.Uint16(header))
+		if length < 2 {
+			return 0, errors.New("invalid JPEG segment length")
+		}
+
+		if marker == 0xE1 {
+			data := make([]byte, length-2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, err
+			}
+			segment := exifOrientationSegment(data)
+			bw.Write(segment)
+			removed += length + 2 - int64(len(segment))
+			marker = 0
+			continue
+		}
+
+		if !keepJPEGSegment(marker) {
+			if _, err := r.Discard(int(length - 2)); err != nil {
+				return 0, err
+			}
+			removed += length + 2
+			marker = 0
+			continue
+		}
+
+		bw.Write([]byte{0xFF, marker, header[0], header[1]})
+		if _, err := io.CopyN(bw, r, length-2); err != nil {
+			return 0, err
+		}
+
+		// start of scan: copy the entropy coded data up to the marker that follows it
+		if marker == 0xDA {
+			var err error
+			if marker, err = copyJPEGScan(bw, r); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		marker = 0
+	}
+}
+
+// copyJPEGScan copies the entropy coded data that follows a start of scan segment and returns
+// the marker that ends it
+func copyJPEGScan(w *bufio.Writer, r *bufio.Reader) (byte, error) {
+	for {
+		data, err := r.ReadSlice(0xFF)
+		if err == bufio.ErrBufferFull {
+			w.Write(data)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		w.Write(data[:len(data)-1])
+
+		// fill bytes before a marker are kept, as they are not metadata
+		next, err := r.ReadByte()
+		for err == nil && next == 0xFF {
+			w.WriteByte(0xFF)
+			next, err = r.ReadByte()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// stuffed zero bytes and restart markers are part of the scan
+		if next == 0x00 || (next >= 0xD0 && next <= 0xD7) {
+			w.Write([]byte{0xFF, next})
+			continue
+		}
+
+		return next, nil
+	}
+}
+
+// stripPNG copies a PNG from r to w without its textual and metadata chunks and returns the number of bytes removed
+func stripPNG(w io.Writer, r *bufio.Reader) (int64, error) {
+	var removed int64
+
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(signature); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+
+		// chunk data plus its CRC
+		if pngMetadataChunks[chunkType] {
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return 0, err
+			}
+			removed += length + 12
+			continue
+		}
+
+		if _, err := w.Write(header); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return 0, err
+		}
+
+		// anything after IEND is not part of the image and may hide data
+		if chunkType == "IEND" {
+			trailing, err := io.Copy(io.Discard, r)
+			if err != nil {
+				return 0, err
+			}
+			return removed + trailing, nil
+		}
+	}
+}
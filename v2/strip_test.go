@@ -0,0 +1,188 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// testJPEGWithMetadata returns a JPEG holding an EXIF segment and a comment, and the size of both segments
+func testJPEGWithMetadata(t *testing.T) ([]byte, int64) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x12}, []byte("Exif\x00\x00GPS 51.5N 0.1W")...)
+	exif = exif[:0x12+2]
+	comment := append([]byte{0xFF, 0xFE, 0x00, 0x0A}, []byte("serial42")...)
+
+	var out bytes.Buffer
+	out.Write(encoded[:2])
+	out.Write(exif)
+	out.Write(comment)
+	out.Write(encoded[2:])
+
+	return out.Bytes(), int64(len(exif) + len(comment))
+}
+
+// testJPEGWithOrientation returns a JPEG whose EXIF segment holds an orientation next to other
+// tags, the APP1 segment expected once it is stripped and the number of bytes removed
+func testJPEGWithOrientation(t *testing.T) ([]byte, []byte, int64) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// big endian TIFF header and an IFD0 with an ASCII artist tag and orientation 6
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x02")
+	tiff = append(tiff, 0x01, 0x3B, 0x00, 0x02, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x26)
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+	tiff = append(tiff, "Jane Doe\x00"...)
+
+	data := append([]byte("Exif\x00\x00"), tiff...)
+	exif := append([]byte{0xFF, 0xE1, 0x00, byte(len(data) + 2)}, data...)
+
+	expected := append([]byte{0xFF, 0xE1, 0x00, 34}, "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01"...)
+	expected = append(expected, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00)
+	expected = append(expected, 0x00, 0x00, 0x00, 0x00)
+
+	var out bytes.Buffer
+	out.Write(encoded[:2])
+	out.Write(exif)
+	out.Write(encoded[2:])
+
+	return out.Bytes(), expected, int64(len(exif) - len(expected))
+}
+
+// testJPEGWithTrailingMetadata returns a JPEG with a comment after its scan and data after its
+// end of image marker, and the size of both
+func testJPEGWithTrailingMetadata(t *testing.T) ([]byte, int64) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	comment := append([]byte{0xFF, 0xFE, 0x00, 0x0A}, []byte("serial42")...)
+	trailer := []byte("GPS 51.5N 0.1W")
+
+	var out bytes.Buffer
+	out.Write(encoded[:len(encoded)-2])
+	out.Write(comment)
+	out.Write(encoded[len(encoded)-2:])
+	out.Write(trailer)
+
+	return out.Bytes(), int64(len(comment) + len(trailer))
+}
+
+// testPNGWithMetadata returns a PNG holding a tEXt chunk, and the size of the chunk
+func testPNGWithMetadata(t *testing.T) ([]byte, int64) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	data := []byte("Author\x00Jane Doe")
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// signature (8) and IHDR (25) come first
+	var out bytes.Buffer
+	out.Write(encoded[:33])
+	out.Write(chunk)
+	out.Write(encoded[33:])
+
+	return out.Bytes(), int64(len(chunk))
+}
+
+func TestTools_UploadFilesStripMetadata(t *testing.T) {
+	jpegData, jpegMetadata := testJPEGWithMetadata(t)
+	pngData, pngMetadata := testPNGWithMetadata(t)
+	orientedData, orientation, orientedMetadata := testJPEGWithOrientation(t)
+	trailingData, trailingMetadata := testJPEGWithTrailingMetadata(t)
+	pngTrailer := []byte("Author: Jane Doe")
+	pngTrailing := append(append([]byte{}, pngData...), pngTrailer...)
+
+	var stripTests = []struct {
+		name            string
+		fileName        string
+		content         []byte
+		strip           bool
+		expectedRemoved int64
+		secret          string
+		kept            []byte
+	}{
+		{name: "jpeg", fileName: "photo.jpg", content: jpegData, strip: true, expectedRemoved: jpegMetadata, secret: "GPS"},
+		{name: "png", fileName: "photo.png", content: pngData, strip: true, expectedRemoved: pngMetadata, secret: "Jane Doe"},
+		{name: "png after end of image", fileName: "photo.png", content: pngTrailing, strip: true, expectedRemoved: pngMetadata + int64(len(pngTrailer)), secret: "Jane Doe"},
+		{name: "jpeg orientation", fileName: "photo.jpg", content: orientedData, strip: true, expectedRemoved: orientedMetadata, secret: "Jane Doe", kept: orientation},
+		{name: "jpeg after scan", fileName: "photo.jpg", content: trailingData, strip: true, expectedRemoved: trailingMetadata, secret: "serial42"},
+		{name: "jpeg after end of image", fileName: "photo.jpg", content: trailingData, strip: true, expectedRemoved: trailingMetadata, secret: "GPS"},
+		{name: "jpeg not stripped", fileName: "photo.jpg", content: jpegData, strip: false, expectedRemoved: 0, secret: ""},
+	}
+
+	for _, entry := range stripTests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, StripMetadata: entry.strip, Digests: []string{"md5"}}
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: entry.content}), "uploads")
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+
+		if uploadedFile.MetadataBytesRemoved != entry.expectedRemoved {
+			t.Errorf("%s: expected %d bytes removed, got %d", entry.name, entry.expectedRemoved, uploadedFile.MetadataBytesRemoved)
+		}
+
+		file, err := store.Get("uploads/" + uploadedFile.NewFileName)
+		if err != nil {
+			t.Fatalf("%s: %v", entry.name, err)
+		}
+		stored, _ := io.ReadAll(file)
+
+		if int64(len(stored)) != uploadedFile.FileSize || uploadedFile.FileSize != int64(len(entry.content))-entry.expectedRemoved {
+			t.Errorf("%s: unexpected stored size %d, reported %d", entry.name, len(stored), uploadedFile.FileSize)
+		}
+
+		if entry.secret != "" && bytes.Contains(stored, []byte(entry.secret)) {
+			t.Errorf("%s: metadata still present in stored file", entry.name)
+		}
+
+		if entry.kept != nil && !bytes.Contains(stored, entry.kept) {
+			t.Errorf("%s: expected segment % x in stored file", entry.name, entry.kept)
+		}
+
+		if _, _, err := image.Decode(bytes.NewReader(stored)); err != nil {
+			t.Errorf("%s: stored image does not decode: %v", entry.name, err)
+		}
+
+		other, _ := testTools.newDigester()
+		other.Write(stored)
+		var expected UploadedFile
+		other.record(&expected)
+		if expected.SHA256 != uploadedFile.SHA256 || expected.Digests["md5"] != uploadedFile.Digests["md5"] {
+			t.Errorf("%s: digests do not match the stored file", entry.name)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SVGPolicy says how uploaded SVG images are sanitized
+type SVGPolicy int
+
+const (
+	SVGUnchanged SVGPolicy = iota // SVG images are saved as uploaded
+	SVGSanitize                   // scripts, event handlers, external references and foreign objects are removed
+	SVGStrict                     // as SVGSanitize, and only allowlisted SVG elements and attributes are kept
+)
+
+// svgBlockedElements are removed from SVG images by SVGSanitize. Names are compared in lower case.
+var svgBlockedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"frame":         true,
+	"frameset":      true,
+	"object":        true,
+	"embed":         true,
+	"applet":        true,
+	"meta":          true,
+	"link":          true,
+	"base":          true,
+	"handler":       true,
+	"listener":      true,
+}
+
+// svgAllowedElements are the only elements kept by SVGStrict
+var svgAllowedElements = map[string]bool{
+	"svg": true, "g": true, "defs": true, "symbol": true, "use": true, "title": true, "desc": true,
+	"path": true, "rect": true, "circle": true, "ellipse": true, "line": true, "polyline": true, "polygon": true,
+	"text": true, "tspan": true, "textPath": true, "image": true,
+	"linearGradient": true, "radialGradient": true, "stop": true,
+	"clipPath": true, "mask": true, "pattern": true, "marker": true,
+	"filter": true, "feGaussianBlur": true, "feOffset": true, "feBlend": true, "feColorMatrix": true,
+	"feComposite": true, "feFlood": true, "feMerge": true, "feMergeNode": true, "feMorphology": true,
+	"feDropShadow": true,
+}
+
+// svgAllowedAttrs are the only attributes without a namespace prefix kept by SVGStrict, besides
+// namespace declarations
+var svgAllowedAttrs = map[string]bool{
+	"id": true, "class": true, "style": true, "lang": true, "transform": true, "href": true,
+	"version": true, "viewBox": true, "preserveAspectRatio": true,
+	"x": true, "y": true, "x1": true, "y1": true, "x2": true, "y2": true, "width": true, "height": true,
+	"cx": true, "cy": true, "r": true, "rx": true, "ry": true, "fx": true, "fy": true, "fr": true,
+	"d": true, "points": true, "pathLength": true, "dx": true, "dy": true, "rotate": true,
+	"textLength": true, "lengthAdjust": true, "startOffset": true,
+	"fill": true, "fill-opacity": true, "fill-rule": true, "opacity": true, "color": true,
+	"stroke": true, "stroke-width": true, "stroke-opacity": true, "stroke-linecap": true,
+	"stroke-linejoin": true, "stroke-miterlimit": true, "stroke-dasharray": true, "stroke-dashoffset": true,
+	"display": true, "visibility": true, "overflow": true, "clip-path": true, "clip-rule": true,
+	"mask": true, "filter": true, "vector-effect": true, "shape-rendering": true,
+	"font-family": true, "font-size": true, "font-style": true, "font-weight": true, "font-variant": true,
+	"text-anchor": true, "dominant-baseline": true, "alignment-baseline": true, "baseline-shift": true,
+	"letter-spacing": true, "word-spacing": true, "text-decoration": true, "writing-mode": true, "direction": true,
+	"marker-start": true, "marker-mid": true, "marker-end": true, "markerWidth": true, "markerHeight": true,
+	"markerUnits": true, "refX": true, "refY": true, "orient": true,
+	"offset": true, "stop-color": true, "stop-opacity": true, "gradientUnits": true,
+	"gradientTransform": true, "spreadMethod": true, "patternUnits": true, "patternContentUnits": true,
+	"patternTransform": true, "clipPathUnits": true, "maskUnits": true, "maskContentUnits": true,
+	"filterUnits": true, "primitiveUnits": true, "in": true, "in2": true, "result": true,
+	"stdDeviation": true, "mode": true, "type": true, "values": true, "operator": true, "radius": true,
+	"k1": true, "k2": true, "k3": true, "k4": true, "flood-color": true, "flood-opacity": true,
+	"color-interpolation-filters": true,
+}
+
+// svgAnimationElements can change attributes of other elements, so they are removed when they target a link or event handler
+var svgAnimationElements = map[string]bool{
+	"set":              true,
+	"animate":          true,
+	"animatecolor":     true,
+	"animatemotion":    true,
+	"animatetransform": true,
+}
+
+// isSVGFile reports whether SVGPolicy applies to the stored file tempName: files detected as SVG,
+// files saved with an .svg extension, and text or XML files whose first element is <svg>. The
+// last catches SVG images whose markup starts too late for the type to be sniffed, e.g. after a
+// long comment, and which a browser would still render as SVG.
+func (t *Tools) isSVGFile(tempName string, uploadedFile *UploadedFile) (bool, error) {
+	if uploadedFile.ContentType == "image/svg+xml" || strings.EqualFold(filepath.Ext(uploadedFile.NewFileName), ".svg") {
+		return true, nil
+	}
+
+	contentType := mediaType(uploadedFile.ContentType)
+	if !strings.HasPrefix(contentType, "text/") && contentType != "application/xml" && !strings.HasSuffix(contentType, "+xml") {
+		return false, nil
+	}
+
+	file, err := t.storage().Get(tempName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// anything that is not well-formed up to its first element is not rendered as SVG
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return false, nil
+		}
+		switch tok := token.(type) {
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) > 0 {
+				return false, nil
+			}
+		case xml.StartElement:
+			return strings.EqualFold(tok.Name.Local, "svg"), nil
+		}
+	}
+}
+
+// sanitizeSVG rewrites the stored SVG image tempName according to SVGPolicy and returns the name
+// of the rewritten temporary file
+func (t *Tools) sanitizeSVG(tempName, uploadDir string, uploadedFile *UploadedFile) (string, error) {
+	var removed int
+	newName, err := t.rewriteFile(tempName, uploadDir, uploadedFile, func(w io.Writer, r io.Reader) error {
+		var err error
+		removed, err = sanitizeSVG(w, r, t.SVGPolicy == SVGStrict)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("file %q could not be sanitized: %w", uploadedFile.OriginalFileName, err)
+	}
+	uploadedFile.SVGElementsRemoved = removed
+
+	return newName, nil
+}
+
+// sanitizeSVG copies the SVG document in r to w without unsafe content and returns the number of
+// elements removed; the children of a removed element are not counted separately. Raw tokens are
+// used so that namespace prefixes are written back exactly as they were read.
+func sanitizeSVG(w io.Writer, r io.Reader, strict bool) (int, error) {
+	decoder := xml.NewDecoder(r)
+	out := &svgWriter{w: w}
+	removed, skip := 0, 0
+
+	// RawToken does not match end tags to start tags, so open elements are tracked here
+	var open []xml.Name
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			if len(open) > 0 {
+				return removed, fmt.Errorf("element <%s> is not closed", qualifiedName(open[len(open)-1]))
+			}
+			return removed, out.err
+		}
+		if err != nil {
+			return removed, err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			open = append(open, tok.Name)
+			if skip > 0 {
+				skip++
+				continue
+			}
+
+			if !keepSVGElement(tok, strict) {
+				removed++
+				skip = 1
+				continue
+			}
+
+			// style sheets are kept only if they reference nothing outside the document
+			if strings.EqualFold(tok.Name.Local, "style") {
+				css, err := readSVGText(decoder)
+				if err != nil {
+					return removed, err
+				}
+				open = open[:len(open)-1]
+				if unsafeSVGValue(css) || strings.Contains(strings.ToLower(unescapeCSS(css)), "@import") {
+					removed++
+					continue
+				}
+				out.start(tok.Name, keepSVGAttrs(tok.Attr, strict))
+				out.text(css)
+				out.end(tok.Name)
+				continue
+			}
+
+			out.start(tok.Name, keepSVGAttrs(tok.Attr, strict))
+
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != tok.Name {
+				return removed, fmt.Errorf("unexpected end element </%s>", qualifiedName(tok.Name))
+			}
+			open = open[:len(open)-1]
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+			out.end(tok.Name)
+
+		case xml.CharData:
+			if skip == 0 {
+				out.text(string(tok))
+			}
+
+		case xml.ProcInst:
+			// only the XML declaration is kept, style sheet instructions can load external content
+			if skip == 0 && tok.Target == "xml" {
+				out.raw("<?xml " + string(tok.Inst) + "?>")
+			}
+
+		case xml.Comment:
+			if skip == 0 && !strict {
+				out.raw("<!--" + string(tok) + "-->")
+			}
+
+			// directives are dropped, so a DOCTYPE cannot declare entities
+		}
+	}
+}
+
+// keepSVGElement reports whether an element survives sanitizing
+func keepSVGElement(el xml.StartElement, strict bool) bool {
+	local := strings.ToLower(el.Name.Local)
+	if svgBlockedElements[local] {
+		return false
+	}
+
+	if svgAnimationElements[local] {
+		for _, attr := range el.Attr {
+			if attr.Name.Local == "attributeName" {
+				target := strings.ToLower(strings.TrimSpace(attr.Value))
+				if strings.HasSuffix(target, "href") || strings.HasPrefix(target, "on") {
+					return false
+				}
+			}
+		}
+	}
+
+	if strict {
+		if el.Name.Space != "" && el.Name.Space != "svg" {
+			return false
+		}
+		return svgAllowedElements[el.Name.Local]
+	}
+
+	return true
+}
+
+// keepSVGAttrs returns the attributes that survive sanitizing
+func keepSVGAttrs(attrs []xml.Attr, strict bool) []xml.Attr {
+	var kept []xml.Attr
+
+	for _, attr := range attrs {
+		local := strings.ToLower(attr.Name.Local)
+
+		switch {
+		case strings.HasPrefix(local, "on"):
+			continue
+		case local == "href":
+			if !localSVGReference(attr.Value) {
+				continue
+			}
+		case unsafeSVGValue(attr.Value):
+			continue
+		}
+
+		// strict mode keeps namespace declarations, xlink:href, xml:* and allowlisted attributes
+		if strict && !keepStrictSVGAttr(attr.Name) {
+			continue
+		}
+
+		kept = append(kept, attr)
+	}
+
+	return kept
+}
+
+// keepStrictSVGAttr reports whether an attribute is kept by SVGStrict
+func keepStrictSVGAttr(name xml.Name) bool {
+	switch name.Space {
+	case "":
+		return name.Local == "xmlns" || svgAllowedAttrs[name.Local]
+	case "xmlns", "xml":
+		return true
+	case "xlink":
+		return name.Local == "href"
+	default:
+		return false
+	}
+}
+
+// localSVGReference reports whether a link points into the document itself or is an embedded raster image
+func localSVGReference(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	for _, prefix := range []string{"#", "data:image/png;", "data:image/jpeg;", "data:image/gif;", "data:image/webp;"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unsafeSVGValue reports whether an attribute value or style sheet runs script or loads anything
+// from outside the document
+func unsafeSVGValue(value string) bool {
+	value = unescapeCSS(value)
+
+	// remove whitespace and control characters, which browsers ignore inside URL schemes
+	normalized := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+
+	if strings.Contains(normalized, "javascript:") || strings.Contains(normalized, "expression(") {
+		return true
+	}
+
+	for rest := normalized; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+4:]
+		if !localSVGReference(strings.TrimLeft(rest, `"'`)) {
+			return true
+		}
+	}
+}
+
+// unescapeCSS decodes the escapes of CSS, such as \75 or \u for "u", so that escaped URL
+// functions and schemes are recognised. Invalid code points become U+FFFD, as in browsers.
+func unescapeCSS(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		// up to six hex digits, followed by an optional white space character
+		j := i + 1
+		for j < len(s) && j < i+7 && isHexDigit(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			// an escaped newline continues the line, any other character stands for itself
+			if s[j] != '\n' {
+				b.WriteByte(s[j])
+			}
+			i = j
+			continue
+		}
+
+		n, _ := strconv.ParseUint(s[i+1:j], 16, 32)
+		if r := rune(n); n == 0 || n > unicode.MaxRune || !utf8.ValidRune(r) {
+			b.WriteRune(utf8.RuneError)
+		} else {
+			b.WriteRune(r)
+		}
+
+		if j+1 < len(s) && s[j] == '\r' && s[j+1] == '\n' {
+			j++
+		}
+		if j < len(s) && strings.IndexByte(" \t\n\r\f", s[j]) >= 0 {
+			j++
+		}
+		i = j - 1
+	}
+
+	return b.String()
+}
+
+// isHexDigit reports whether c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// readSVGText returns the text of the element just started, consuming its end tag. Nested
+// elements are not expected in a style sheet, so they are dropped.
+func readSVGText(decoder *xml.Decoder) (string, error) {
+	var text strings.Builder
+
+	for depth := 1; depth > 0; {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return "", err
+		}
+
+		switch tok := token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 1 {
+				text.Write(tok)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
+
+// svgWriter writes raw XML tokens, remembering the first error
+type svgWriter struct {
+	w   io.Writer
+	err error
+}
+
+// raw writes str unchanged
+func (s *svgWriter) raw(str string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, str)
+	}
+}
+
+// text writes escaped character data
+func (s *svgWriter) text(str string) {
+	if s.err == nil {
+		s.err = xml.EscapeText(s.w, []byte(str))
+	}
+}
+
+// start writes a start tag
+func (s *svgWriter) start(name xml.Name, attrs []xml.Attr) {
+	s.raw("<" + qualifiedName(name))
+	for _, attr := range attrs {
+		s.raw(" " + qualifiedName(attr.Name) + `="`)
+		s.text(attr.Value)
+		s.raw(`"`)
+	}
+	s.raw(">")
+}
+
+// end writes an end tag
+func (s *svgWriter) end(name xml.Name) {
+	s.raw("</" + qualifiedName(name) + ">")
+}
+
+// qualifiedName returns name with its namespace prefix, as read by RawToken
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
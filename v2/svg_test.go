@@ -0,0 +1,195 @@
+package toolkit
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var sanitizeSVGTests = []struct {
+	name            string
+	svg             string
+	strict          bool
+	expected        string
+	expectedRemoved int
+}{
+	{
+		name:     "clean",
+		svg:      `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10" fill="red"/></svg>`,
+		expected: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10" fill="red"></rect></svg>`,
+	},
+	{
+		name:            "script",
+		svg:             `<svg><script>alert(1)</script><SCRIPT>alert(2)</SCRIPT><circle r="1"/></svg>`,
+		expected:        `<svg><circle r="1"></circle></svg>`,
+		expectedRemoved: 2,
+	},
+	{
+		name:     "event handlers",
+		svg:      `<svg onload="alert(1)"><rect ONCLICK="alert(1)" width="1"/></svg>`,
+		expected: `<svg><rect width="1"></rect></svg>`,
+	},
+	{
+		name:            "foreign object",
+		svg:             `<svg><foreignObject><div xmlns="http://www.w3.org/1999/xhtml"><img src="x" onerror="alert(1)"/></div></foreignObject></svg>`,
+		expected:        `<svg></svg>`,
+		expectedRemoved: 1,
+	},
+	{
+		name:     "external references",
+		svg:      `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="http://evil.example/x.svg#a"/><use href="#local"/><a href="java&#x09;script:alert(1)"><rect fill="url(https://evil.example/p)" stroke="url(#grad)"/></a></svg>`,
+		expected: `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use></use><use href="#local"></use><a><rect stroke="url(#grad)"></rect></a></svg>`,
+	},
+	{
+		name:            "animation of links",
+		svg:             `<svg><a href="#x"><set attributeName="href" to="javascript:alert(1)"/></a><animate attributeName="opacity" from="0" to="1"/></svg>`,
+		expected:        `<svg><a href="#x"></a><animate attributeName="opacity" from="0" to="1"></animate></svg>`,
+		expectedRemoved: 1,
+	},
+	{
+		name:            "style sheets",
+		svg:             `<svg><style>.a { fill: red }</style><style>@import url(https://evil.example/x.css);</style></svg>`,
+		expected:        `<svg><style>.a { fill: red }</style></svg>`,
+		expectedRemoved: 1,
+	},
+	{
+		name:     "css escapes",
+		svg:      `<svg><rect style="fill:\75 rl(http://evil/p)" width="1"/><rect fill="u\rl(https://evil.example/p)"/><rect style="fill:\000075rl(#grad)"/></svg>`,
+		strict:   true,
+		expected: `<svg><rect width="1"></rect><rect></rect><rect style="fill:\000075rl(#grad)"></rect></svg>`,
+	},
+	{
+		name:            "escaped import",
+		svg:             `<svg><style>@\69mport "https://evil.example/x.css";</style></svg>`,
+		expected:        `<svg></svg>`,
+		expectedRemoved: 1,
+	},
+	{
+		name:     "processing instructions and doctype",
+		svg:      `<?xml version="1.0"?><?xml-stylesheet href="https://evil.example/x.css"?><!DOCTYPE svg [<!ENTITY x "y">]><svg></svg>`,
+		expected: `<?xml version="1.0"?><svg></svg>`,
+	},
+	{
+		name:            "strict",
+		svg:             `<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" inkscape:version="1.0"><!-- made by hand --><inkscape:grid/><style>.a { fill: red }</style><a href="#x"><path d="M0 0"/></a><g><text>hi</text></g></svg>`,
+		strict:          true,
+		expected:        `<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"><g><text>hi</text></g></svg>`,
+		expectedRemoved: 3,
+	},
+	{
+		name:     "strict attributes",
+		svg:      `<svg viewBox="0 0 1 1" data-x="1" xml:space="preserve"><rect width="1" fill="red" requiredExtensions="x" systemLanguage="en" tabindex="0"/></svg>`,
+		strict:   true,
+		expected: `<svg viewBox="0 0 1 1" xml:space="preserve"><rect width="1" fill="red"></rect></svg>`,
+	},
+}
+
+func TestSanitizeSVG(t *testing.T) {
+	for _, entry := range sanitizeSVGTests {
+		var out bytes.Buffer
+
+		removed, err := sanitizeSVG(&out, strings.NewReader(entry.svg), entry.strict)
+		if err != nil {
+			t.Errorf("%s: %v", entry.name, err)
+			continue
+		}
+
+		if out.String() != entry.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", entry.name, entry.expected, out.String())
+		}
+
+		if removed != entry.expectedRemoved {
+			t.Errorf("%s: expected %d elements removed, got %d", entry.name, entry.expectedRemoved, removed)
+		}
+	}
+}
+
+func TestTools_UploadFilesSanitizeSVG(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, AllowedFileTypes: []string{"image/svg+xml"}, SVGPolicy: SVGSanitize}
+
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1"/></svg>`
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "logo.svg", content: []byte(svg)})
+
+	uploadedFile, err := testTools.UploadFile(request, "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploadedFile.SVGElementsRemoved != 1 {
+		t.Errorf("expected 1 element removed, got %d", uploadedFile.SVGElementsRemoved)
+	}
+
+	file, err := store.Get(filepath.Join("uploads", uploadedFile.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	stored, _ := io.ReadAll(file)
+	if bytes.Contains(stored, []byte("script")) {
+		t.Errorf("expected script to be removed, got %s", stored)
+	}
+
+	if uploadedFile.FileSize != int64(len(stored)) {
+		t.Errorf("expected size %d, got %d", len(stored), uploadedFile.FileSize)
+	}
+}
+
+var disguisedSVGTests = []struct {
+	name            string
+	fileName        string
+	content         string
+	expectedRemoved int
+	unchanged       bool
+}{
+	{name: "long comment", fileName: "logo.svg", content: "<!--" + strings.Repeat("x", 600) + `--><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1"/></svg>`, expectedRemoved: 1},
+	{name: "long comment text name", fileName: "logo.txt", content: "<!--" + strings.Repeat("x", 600) + `--><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1"/></svg>`, expectedRemoved: 1},
+	{name: "long whitespace", fileName: "logo.xml", content: "<?xml version=\"1.0\"?>" + strings.Repeat(" ", 600) + `<svg onload="alert(1)"><rect width="1"/></svg>`},
+	{name: "plain text", fileName: "notes.txt", content: "alert(1) is not <svg> markup", unchanged: true},
+	{name: "html", fileName: "page.html", content: "<!--" + strings.Repeat("x", 600) + `--><html><script>alert(1)</script></html>`, unchanged: true},
+}
+
+func TestTools_UploadFilesDisguisedSVG(t *testing.T) {
+	for _, entry := range disguisedSVGTests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, AllowedFileTypes: []string{"text/*", "application/xml"}, SVGPolicy: SVGSanitize}
+
+		uploadedFile, err := testTools.UploadFile(newMultipartRequest(t, testFormFile{field: "file", fileName: entry.fileName, content: []byte(entry.content)}), "uploads")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+			continue
+		}
+
+		if uploadedFile.SVGElementsRemoved != entry.expectedRemoved {
+			t.Errorf("%s: expected %d elements removed, got %d", entry.name, entry.expectedRemoved, uploadedFile.SVGElementsRemoved)
+		}
+
+		file, err := store.Get(filepath.Join("uploads", uploadedFile.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := io.ReadAll(file)
+		file.Close()
+
+		if entry.unchanged && string(stored) != entry.content {
+			t.Errorf("%s: expected file to be unchanged, got %s", entry.name, stored)
+		}
+
+		if !entry.unchanged && bytes.Contains(stored, []byte("alert")) {
+			t.Errorf("%s: expected script to be removed, got %s", entry.name, stored)
+		}
+	}
+}
+
+func TestTools_UploadFilesInvalidSVG(t *testing.T) {
+	testTools := Tools{AllowedFileTypes: []string{"image/svg+xml"}, SVGPolicy: SVGStrict}
+
+	request := newMultipartRequest(t, testFormFile{field: "file", fileName: "broken.svg", content: []byte(`<svg><rect></svg>`)})
+
+	if _, err := testTools.UploadFile(request, t.TempDir()); err == nil {
+		t.Error("expected malformed SVG to be rejected")
+	}
+}
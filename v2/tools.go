@@ -2,38 +2,86 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
+
+// tempFilePrefix and tempFileSuffix mark files that are still being uploaded
+const (
+	tempFilePrefix = ".upload-"
+	tempFileSuffix = ".tmp"
 )
 
 // randomStringSource is a string of characters used to generate random strings
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890_+"
 
-// Tools is a struct that contains useful utilities for applications
+// Tools is a struct that contains useful utilities for applications.
+// Uploaded files are saved through Storage, which defaults to the local filesystem.
 type Tools struct {
-	MaxFileSize        int64
+	MaxFileSize        int64 // per-file limit in bytes, 1GB if not set
+	MaxTotalUploadSize int64 // limit in bytes for all files in one request, unlimited if not set
+	MaxFileCount       int   // limit on files in one request, unlimited if not set
 	AllowedFileTypes   []string
 	MaxJSONSize        int64
 	AllowUnknownFields bool
+	Storage            Storage
+	StreamUploads      bool         // read multipart bodies part by part instead of calling ParseMultipartForm
+	AtomicUploads      bool         // keep all files of a request only if every file is accepted
+	Digests            []string     // extra digests to compute for uploaded files: md5, sha1, sha256 or sha512
+	ContentAddressed   bool         // name files after their SHA-256 and reuse identical files already stored
+	MaxImageWidth      int          // largest accepted image width in pixels, unlimited if not set
+	MaxImageHeight     int          // largest accepted image height in pixels, unlimited if not set
+	MaxImagePixels     int64        // largest accepted width*height; if not set, 40 megapixels with Derivatives and unlimited without
+	Derivatives        []Derivative // scaled copies to generate next to each uploaded image
+	StripMetadata      bool         // remove EXIF (except the orientation), XMP and text metadata from JPEG and PNG uploads
+	Scanner            Scanner      // checks each file before it is moved into place
+	QuarantineDir      string       // where files rejected by Scanner are moved, deleted if not set
+
+	RequireMatchingExtension bool                         // reject files whose extension does not match their detected type
+	MaxFileNameLength        int                          // longest file name kept when files are not renamed, 255 bytes if not set
+	FileNameCollision        CollisionStrategy            // what to do when a file with the same name already exists
+	ShardLayout              ShardLayout                  // spread renamed files over subdirectories of the upload directory
+	FieldRules               map[string]FieldRule         // per form field limits; if set, files from other fields are rejected
+	ValidatePDF              bool                         // check the structure of uploaded PDFs
+	RejectPDFFeatures        PDFFeature                   // active PDF content to reject, e.g. PDFJavaScript|PDFLaunchAction
+	SVGPolicy                SVGPolicy                    // sanitize uploaded SVG images
+	Metadata                 MetadataStore                // records the metadata of every saved file
+	Uploader                 func(r *http.Request) string // identifies the uploader for Metadata, e.g. from a session
+	SigningKey               []byte                       // HMAC key for signed upload tokens, at least 16 bytes
+	Hooks                    UploadHooks                  // called as files are accepted, rejected and stored
+	Events                   EventPublisher               // receives an UploadEvent for every hook
+	Quota                    QuotaProvider                // consulted by UploadFilesWithQuota
+	MaxArchiveEntries        int                          // limits for ExtractArchive; zero uses the defaults
+	MaxArchiveSize           int64                        // total uncompressed size
+	MaxCompressionRatio      int                          // uncompressed to compressed size
+	UploadWorkers            int                          // files of a parsed form stored concurrently; the Scanner must be safe for concurrent use
+
+	policyFileTypes []string // types allowed by a signed upload token, which files must match as well
 }
 
-// CheckFileType checks if a file type is allowed
-func (t *Tools) CheckFileType(fileType string) bool {
-	if len(t.AllowedFileTypes) == 0 {
-		t.AllowedFileTypes = []string{"image/jpeg", "image/jpg", "image/png", "image/gif", "application/pdf"}
-	}
+// defaultAllowedFileTypes is used when AllowedFileTypes is empty
+var defaultAllowedFileTypes = []string{"image/jpeg", "image/jpg", "image/png", "image/gif", "application/pdf"}
 
-	for _, t := range t.AllowedFileTypes {
-		if strings.EqualFold(fileType, t) {
+// CheckFileType checks if a file type is allowed. Allowed types may use wildcards, e.g. "image/*".
+func (t *Tools) CheckFileType(fileType string) bool {
+	for _, t := range t.allowedFileTypes("") {
+		if matchFileType(t, fileType) {
 			return true
 		}
 	}
@@ -54,70 +102,409 @@ func (t *Tools) CreateDirIfNotExist(dir string) error {
 }
 
 // DownloadStaticFile sends file to the client and attempts to force the browser to download the file,
-// saving it as the value provided in the displayName parameter
+// saving it as the value provided in the displayName parameter. If displayName is empty, the original
+// file name is restored from Metadata.
 func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, pathName, displayName string) {
-	if _, err := os.Stat(pathName); os.IsNotExist(err) {
+	store := t.storage()
+
+	info, err := store.Stat(pathName)
+	if errors.Is(err, fs.ErrNotExist) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := store.Get(pathName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
+	// an empty display name is restored from the file's metadata
+	if displayName == "" {
+		displayName = t.displayName(pathName)
+	}
+
+	w.Header().Set("Content-Disposition", contentDisposition(displayName))
 
-	http.ServeFile(w, r, pathName)
+	// serve seekable files with range support, stream everything else
+	if rs, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, filepath.Base(pathName), info.ModTime(), rs)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	_, _ = io.Copy(w, file)
 }
 
-// GetNewFileName generates a new file name
+// GetNewFileName generates a new file name. If renameFile is false, the original file name is
+// kept after passing it through SanitizeFileName.
 func (t *Tools) GetNewFileName(fileHeader *multipart.FileHeader, renameFile bool) string {
+	fileName := t.SanitizeFileName(fileHeader.Filename)
+
 	if renameFile {
-		return fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(fileHeader.Filename))
+		return fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(fileName))
 	}
 
-	return fileHeader.Filename
+	return fileName
 }
 
 // HandleFile processes a single file and returns an UploadedFile and an error
 func (t *Tools) HandleFile(fileHeader *multipart.FileHeader, uploadDir string, renameFile bool) (*UploadedFile, error) {
-	var uploadedFile UploadedFile
+	batch := &uploadBatch{}
+	uploadedFile, err := t.handleFile("", fileHeader, uploadDir, renameFile, batch)
+
+	return t.completeFile(batch, uploadedFile, err)
+}
+
+// handleFile opens a file parsed from form field and saves it as part of batch
+func (t *Tools) handleFile(field string, fileHeader *multipart.FileHeader, uploadDir string, renameFile bool, batch *uploadBatch) (*UploadedFile, error) {
 	infile, err := fileHeader.Open()
 	if err != nil {
 		return nil, err
 	}
 	defer infile.Close()
 
-	buff := make([]byte, 512)
-	_, err = infile.Read(buff)
+	return t.saveFile(infile, field, fileHeader, uploadDir, renameFile, batch)
+}
+
+// saveFile checks the type of the file read from src and writes it to uploadDir.
+// src is read exactly once, so it may be a file opened from a parsed form or a streamed part.
+func (t *Tools) saveFile(src io.Reader, field string, fileHeader *multipart.FileHeader, uploadDir string, renameFile bool, batch *uploadBatch) (*UploadedFile, error) {
+	if err := t.startFile(batch, field, fileHeader.Filename); err != nil {
+		t.fileRejected(batch, rejectedFile(field, fileHeader.Filename), err)
+		return nil, err
+	}
+
+	uploadedFile, tempName, err := t.storeFile(src, field, fileHeader, uploadDir, renameFile, batch)
 	if err != nil {
+		t.fileRejected(batch, rejectedFile(field, fileHeader.Filename), err)
 		return nil, err
 	}
 
-	// check to see if file type is permitted
-	fileType := http.DetectContentType(buff)
-	if !t.CheckFileType(fileType) {
-		return nil, errors.New("file type not permitted")
+	return t.placeFile(tempName, uploadDir, renameFile, uploadedFile, batch)
+}
+
+// storeFile checks the type of the file read from src, writes it to a temporary file in uploadDir
+// and inspects it. It only touches the limits of batch, so files can be stored concurrently.
+func (t *Tools) storeFile(src io.Reader, field string, fileHeader *multipart.FileHeader, uploadDir string, renameFile bool, batch *uploadBatch) (*UploadedFile, string, error) {
+	var uploadedFile UploadedFile
+
+	src = t.limitReader(src, batch, field, fileHeader.Filename)
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(src, buff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, "", err
 	}
+	buff = buff[:n]
 
-	// be kind, rewind
-	_, err = infile.Seek(0, 0)
-	if err != nil {
-		return nil, err
+	// check to see if file type is permitted
+	fileType := refineFileType(fileHeader.Filename, t.DetectFileType(buff))
+	if err := t.checkSniffedType(field, fileHeader.Filename, fileType); err != nil {
+		return nil, "", err
 	}
 
+	uploadedFile.FieldName = field
 	uploadedFile.OriginalFileName = fileHeader.Filename
 	uploadedFile.NewFileName = t.GetNewFileName(fileHeader, renameFile)
+	uploadedFile.ContentType = fileType
+	if isReservedFileName(uploadedFile.NewFileName) {
+		return nil, "", fmt.Errorf("file name %q is reserved", uploadedFile.NewFileName)
+	}
+	if err := t.fileAccepted(batch, &uploadedFile); err != nil {
+		return nil, "", err
+	}
 
-	var outfile *os.File
-	defer outfile.Close()
+	digests, err := t.newDigester()
+	if err != nil {
+		return nil, "", err
+	}
 
-	if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-		return nil, err
+	// put the sniffed bytes back in front of the rest of the file, hashing everything as it is copied
+	src = io.TeeReader(io.MultiReader(bytes.NewReader(buff), src), digests)
+	src = batch.wrapReader(src, fileHeader.Filename)
+
+	// write to a temporary name first, so that a failed upload never leaves a partial file in place
+	tempName := filepath.Join(uploadDir, tempFilePrefix+t.RandomString(16)+tempFileSuffix)
+	fileSize, err := t.storage().Put(tempName, src)
+	if err != nil {
+		_ = t.storage().Delete(tempName)
+		return nil, "", err
 	}
-	fileSize, err := io.Copy(outfile, infile)
+	uploadedFile.FileSize = fileSize
+	digests.record(&uploadedFile)
+
+	tempName, err = t.prepareFile(tempName, uploadDir, &uploadedFile)
 	if err != nil {
+		return nil, "", err
+	}
+
+	return &uploadedFile, tempName, nil
+}
+
+// placeFile names the stored file tempName and adds it to the pending files of batch, committing
+// it right away unless the batch is atomic
+func (t *Tools) placeFile(tempName, uploadDir string, renameFile bool, uploadedFile *UploadedFile, batch *uploadBatch) (*UploadedFile, error) {
+	// anything left pending by a failed file is removed, so that earlier files are unaffected
+	start := len(batch.pending)
+	if err := t.finishFile(tempName, uploadDir, renameFile, uploadedFile, batch); err != nil {
+		for _, p := range batch.pending[start:] {
+			_ = t.storage().Delete(p.tempName)
+		}
+		batch.pending = batch.pending[:start]
+		t.fileRejected(batch, uploadedFile, err)
 		return nil, err
 	}
-	uploadedFile.FileSize = fileSize
+	batch.placed = append(batch.placed, uploadedFile)
+
+	if !batch.atomic {
+		if err := t.commitBatch(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	return uploadedFile, nil
+}
+
+// prepareFile checks and processes the complete file stored under tempName and returns the name
+// of the temporary file now holding it. The temporary file is removed on error.
+func (t *Tools) prepareFile(tempName, uploadDir string, uploadedFile *UploadedFile) (_ string, err error) {
+	defer func() {
+		if err != nil {
+			_ = t.storage().Delete(tempName)
+		}
+	}()
+
+	if err := t.inspectFile(tempName, uploadedFile); err != nil {
+		return "", err
+	}
+
+	if t.StripMetadata {
+		stripped, err := t.stripMetadata(tempName, uploadDir, uploadedFile)
+		if err != nil {
+			return "", err
+		}
+		tempName = stripped
+	}
+
+	if t.SVGPolicy != SVGUnchanged {
+		svg, err := t.isSVGFile(tempName, uploadedFile)
+		if err != nil {
+			return "", err
+		}
+		if svg {
+			sanitized, err := t.sanitizeSVG(tempName, uploadDir, uploadedFile)
+			if err != nil {
+				return "", err
+			}
+			tempName = sanitized
+		}
+	}
+
+	if t.Scanner != nil {
+		if err := t.scanFile(tempName, uploadedFile); err != nil {
+			return "", err
+		}
+	}
+
+	return tempName, nil
+}
+
+// finishFile names the prepared file stored under tempName and adds it to the pending files of
+// batch under its final name. The temporary file is removed on error.
+func (t *Tools) finishFile(tempName, uploadDir string, renameFile bool, uploadedFile *UploadedFile, batch *uploadBatch) (err error) {
+	defer func() {
+		if err != nil {
+			_ = t.storage().Delete(tempName)
+		}
+	}()
+
+	// original names are kept where the client put them, only generated names are sharded
+	subdir := batch.subdir
+	if renameFile || t.ContentAddressed {
+		if t.ContentAddressed {
+			uploadedFile.NewFileName = t.contentAddressedName(uploadedFile)
+		}
+		subdir = filepath.Join(subdir, t.shardDir(uploadedFile.NewFileName))
+	}
+	dir := filepath.Join(uploadDir, subdir)
+
+	if t.ContentAddressed {
+		if name := filepath.Join(dir, uploadedFile.NewFileName); t.isDuplicate(name, batch) {
+			// a permanent copy of a temporary file claims it, so that it is not removed under
+			// the new upload
+			if batch.ttl == 0 {
+				if expiry, err := t.loadExpiry(name); err == nil {
+					if err := t.deleteExpiry(name, expiry); err != nil {
+						return err
+					}
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+
+			// derivatives were made when the original was stored
+			uploadedFile.Path = filepath.ToSlash(filepath.Join(subdir, uploadedFile.NewFileName))
+			uploadedFile.Duplicate = true
+			_ = t.storage().Delete(tempName)
+			return nil
+		}
+	} else {
+		uploadedFile.NewFileName, err = t.resolveCollision(dir, uploadedFile.NewFileName, batch)
+		if err != nil {
+			return err
+		}
+	}
+	uploadedFile.Path = filepath.ToSlash(filepath.Join(subdir, uploadedFile.NewFileName))
+
+	if subdir != "" && t.Storage == nil {
+		if err := t.CreateDirIfNotExist(dir); err != nil {
+			return err
+		}
+	}
+
+	if err := t.createDerivatives(tempName, dir, uploadedFile, batch); err != nil {
+		return err
+	}
+
+	p := pendingFile{tempName: tempName, name: filepath.Join(dir, uploadedFile.NewFileName)}
+	if t.Metadata != nil {
+		p.metadata = t.fileMetadata(uploadedFile, batch)
+	}
+	if batch.ttl > 0 {
+		p.expiry = t.newFileExpiry(uploadDir, uploadedFile, batch.ttl)
+	}
+	batch.pending = append(batch.pending, p)
+
+	return nil
+}
+
+// inspectFile runs the checks that need the complete file against the stored temporary file name
+func (t *Tools) inspectFile(name string, uploadedFile *UploadedFile) error {
+	if mediaType(uploadedFile.ContentType) == "application/zip" {
+		if err := t.inspectZipType(name, uploadedFile); err != nil {
+			return err
+		}
+	}
+
+	if isInspectedImage(uploadedFile.ContentType) {
+		if err := t.inspectImage(name, uploadedFile); err != nil {
+			return err
+		}
+	}
+
+	if t.ValidatePDF && uploadedFile.ContentType == "application/pdf" {
+		if err := t.inspectPDF(name, uploadedFile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadBatch holds the state shared by all files handled in a single upload request
+type uploadBatch struct {
+	mu         sync.Mutex // guards total while files are stored concurrently
+	files      int
+	fieldFiles map[string]int
+	total      int64
+	atomic     bool
+	pending    []pendingFile
+	placed     []*UploadedFile // files whose pending entries are waiting to be committed
+	values     url.Values      // non-file values of a streamed form, collected only when they are decoded
+	form       interface{}     // struct the form values are decoded into, if any
+	ctx        context.Context
+	progress   ProgressFunc
+	quota      *batchQuota
+	request    *http.Request // the request the files came from, if any
+	subdir     string        // directory below the upload directory the next file goes to
+	ttl        time.Duration // lifetime of temporary files, which are kept if zero
+}
+
+// pendingFile is a file written under a temporary name, waiting to be renamed into place
+type pendingFile struct {
+	tempName string
+	name     string
+	metadata *FileMetadata // saved once the file is in place, if Tools.Metadata is set
+	expiry   *fileExpiry   // saved before the file is moved into place, for temporary files
+}
+
+// commitBatch renames every pending file into place and reports the placed files as stored. If
+// any rename fails, the files already committed by this call and all remaining temporary files
+// are removed, and the placed files are reported as rejected.
+func (t *Tools) commitBatch(batch *uploadBatch) error {
+	for i, p := range batch.pending {
+		if err := t.commitFile(p); err != nil {
+			for _, done := range batch.pending[:i] {
+				t.uncommitFile(done)
+			}
+			batch.pending = batch.pending[i:]
+			t.discardBatch(batch, err)
+			return err
+		}
+	}
+	batch.pending = nil
 
-	return &uploadedFile, nil
+	for _, uploadedFile := range batch.placed {
+		t.fileStored(batch, uploadedFile)
+	}
+	batch.placed = nil
+
+	return nil
+}
+
+// commitFile moves the pending file p into place along with its expiry record and metadata,
+// removing whatever it wrote on error. The expiry record goes first, so that a temporary file
+// never exists without one.
+func (t *Tools) commitFile(p pendingFile) error {
+	if p.expiry != nil {
+		if err := t.saveExpiry(p.name, *p.expiry); err != nil {
+			return err
+		}
+	}
+
+	err := t.moveFile(p.tempName, p.name)
+	if err == nil && p.metadata != nil {
+		if err = t.Metadata.Save(p.name, *p.metadata); err != nil {
+			_ = t.storage().Delete(p.name)
+		}
+	}
+
+	if err != nil && p.expiry != nil {
+		_ = t.deleteExpiry(p.name, *p.expiry)
+	}
+
+	return err
+}
+
+// uncommitFile removes a pending file committed by commitFile
+func (t *Tools) uncommitFile(p pendingFile) {
+	_ = t.storage().Delete(p.name)
+	if p.metadata != nil {
+		_ = t.Metadata.Delete(p.name)
+	}
+	if p.expiry != nil {
+		_ = t.deleteExpiry(p.name, *p.expiry)
+	}
+}
+
+// discardBatch removes the temporary files of every pending file and reports the placed files
+// as rejected because of err
+func (t *Tools) discardBatch(batch *uploadBatch, err error) {
+	for _, p := range batch.pending {
+		_ = t.storage().Delete(p.tempName)
+	}
+	batch.pending = nil
+
+	for _, uploadedFile := range batch.placed {
+		t.fileRejected(batch, uploadedFile, err)
+	}
+	batch.placed = nil
 }
 
 // RandomString generates a random string of length n
@@ -135,9 +522,24 @@ func (t *Tools) RandomString(length int) string {
 
 // UploadedFile is used to save information about an uploaded file
 type UploadedFile struct {
-	NewFileName      string
-	OriginalFileName string
-	FileSize         int64
+	NewFileName          string
+	Path                 string // slash separated path of the file relative to the upload directory
+	FieldName            string // name of the form field the file was sent in
+	OriginalFileName     string
+	FileSize             int64
+	ContentType          string               // detected MIME type of the file contents
+	Width                int                  // pixel width, for PNG, JPEG and GIF images whose header could be read
+	Height               int                  // pixel height, for PNG, JPEG and GIF images whose header could be read
+	Frames               int                  // number of frames, for PNG, JPEG and GIF images whose header could be read
+	Derivatives          []UploadedDerivative // scaled copies generated for images when Tools.Derivatives is set
+	PageCount            int                  // pages of a PDF, when Tools.ValidatePDF is set
+	PDFEncrypted         bool
+	PDFFeatures          PDFFeature        // active content found in a PDF
+	SVGElementsRemoved   int               // number of elements removed from an SVG image by SVGPolicy
+	MetadataBytesRemoved int64             // bytes of metadata removed when Tools.StripMetadata is set
+	SHA256               string            // hex encoded SHA-256 of the file contents
+	Digests              map[string]string // hex encoded digests requested in Tools.Digests, keyed by name
+	Duplicate            bool              // true if ContentAddressed found an identical file already stored
 }
 
 // Slugify converts string s into an URL safe slug
@@ -171,6 +573,10 @@ func (t *Tools) UploadFile(r *http.Request, uploadDir string, rename ...bool) (*
 		return nil, err
 	}
 
+	if len(files) == 0 {
+		return nil, errors.New("no file was uploaded")
+	}
+
 	return files[0], nil
 }
 
@@ -184,25 +590,109 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 		renameFile = rename[0]
 	}
 
-	var uploadedFiles []*UploadedFile
+	return t.uploadFiles(r, uploadDir, renameFile, &uploadBatch{}, nil)
+}
 
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
+// uploadFiles saves the files of a multipart form as batch and, if data is not nil, decodes the
+// other form values into it. A parsed form is decoded before any file is handled. A streamed
+// form may send values after its files, so the batch is made atomic and its files are only
+// committed once the values have been decoded.
+func (t *Tools) uploadFiles(r *http.Request, uploadDir string, renameFile bool, batch *uploadBatch, data interface{}) (uploadedFiles []*UploadedFile, err error) {
+	defer func() {
+		t.uploadComplete(batch, uploadedFiles, err)
+	}()
+
+	batch.atomic = t.AtomicUploads
+	batch.request = r
+	batch.form = data
+	if data != nil && t.StreamUploads {
+		batch.atomic = true
+		batch.values = url.Values{}
+	}
+
+	// remote storage backends have no directories to create
+	if t.Storage == nil {
+		err := t.CreateDirIfNotExist(uploadDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if t.StreamUploads {
+		uploadedFiles, err = t.streamFiles(r, uploadDir, renameFile, batch)
+	} else {
+		uploadedFiles, err = t.parseFiles(r, uploadDir, renameFile, batch)
+	}
+
+	if err == nil && batch.values != nil {
+		err = t.decodeForm(batch.values, data)
 	}
 
-	err := t.CreateDirIfNotExist(uploadDir)
 	if err != nil {
+		if batch.atomic {
+			t.discardBatch(batch, err)
+			return nil, err
+		}
+		return uploadedFiles, err
+	}
+
+	// a request cancelled after its last file was written still keeps nothing
+	if err := batch.err(); err != nil {
+		t.discardBatch(batch, err)
+		return nil, err
+	}
+
+	if err := t.commitBatch(batch); err != nil {
 		return nil, err
 	}
 
-	err = r.ParseMultipartForm(t.MaxFileSize)
+	return uploadedFiles, nil
+}
+
+// parseFiles parses the whole multipart form and then saves each file in it
+func (t *Tools) parseFiles(r *http.Request, uploadDir string, renameFile bool, batch *uploadBatch) ([]*UploadedFile, error) {
+	var uploadedFiles []*UploadedFile
+
+	// stop ParseMultipartForm from spooling a body far beyond the limit to disk
+	if t.MaxTotalUploadSize > 0 && r.MultipartForm == nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, t.MaxTotalUploadSize+multipartOverhead)
+	}
+
+	err := r.ParseMultipartForm(multipartMaxMemory)
 	if err != nil {
-		return nil, errors.New("the uploaded file is too big")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &UploadLimitError{Limit: LimitTotalUploadSize, Max: t.MaxTotalUploadSize}
+		}
+		return nil, fmt.Errorf("error parsing multipart form: %w", err)
+	}
+
+	if batch.form != nil {
+		if err := t.decodeForm(r.MultipartForm.Value, batch.form); err != nil {
+			return nil, err
+		}
 	}
 
-	for _, fileHeaders := range r.MultipartForm.File {
-		for _, fileHeader := range fileHeaders {
-			uploadedFile, err := t.HandleFile(fileHeader, uploadDir, renameFile)
+	// handle fields in a stable order
+	fields := make([]string, 0, len(r.MultipartForm.File))
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	if t.UploadWorkers > 1 {
+		var jobs []fileJob
+		for _, field := range fields {
+			for _, fileHeader := range r.MultipartForm.File[field] {
+				jobs = append(jobs, fileJob{field: field, fileHeader: fileHeader})
+			}
+		}
+		return t.handleFiles(jobs, uploadDir, renameFile, batch)
+	}
+
+	for _, field := range fields {
+		for _, fileHeader := range r.MultipartForm.File[field] {
+			uploadedFile, err := t.handleFile(field, fileHeader, uploadDir, renameFile, batch)
 			if err != nil {
 				return uploadedFiles, err
 			}
@@ -213,6 +703,56 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 	return uploadedFiles, nil
 }
 
+// streamFiles reads the multipart body part by part, checking and saving each file as it arrives
+// instead of buffering the whole form first. Non-file parts are kept in batch.values if it is
+// set, and skipped otherwise.
+func (t *Tools) streamFiles(r *http.Request, uploadDir string, renameFile bool, batch *uploadBatch) ([]*UploadedFile, error) {
+	var uploadedFiles []*UploadedFile
+	var valuesSize int64
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return uploadedFiles, err
+		}
+
+		if part.FileName() == "" {
+			if batch.values != nil {
+				value, err := io.ReadAll(io.LimitReader(part, maxFormValuesSize-valuesSize+1))
+				if err != nil {
+					_ = part.Close()
+					return uploadedFiles, err
+				}
+				valuesSize += int64(len(value))
+				if valuesSize > maxFormValuesSize {
+					_ = part.Close()
+					return uploadedFiles, fmt.Errorf("form values must not be larger than %d bytes", maxFormValuesSize)
+				}
+				batch.values.Add(part.FormName(), string(value))
+			}
+			_ = part.Close()
+			continue
+		}
+
+		uploadedFile, err := t.saveFile(part, part.FormName(), &multipart.FileHeader{Filename: part.FileName()}, uploadDir, renameFile, batch)
+		_ = part.Close()
+		if err != nil {
+			return uploadedFiles, err
+		}
+		uploadedFiles = append(uploadedFiles, uploadedFile)
+	}
+
+	return uploadedFiles, nil
+}
+
 // JSONResponse defines the contract for a JSON response
 type JSONResponse struct {
 	Error   bool        `json:"error"`
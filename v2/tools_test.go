@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -28,6 +29,52 @@ func MockTestClient(fn RoundTripFunc) *http.Client {
 	}
 }
 
+// testFormFile describes a single file part used to build multipart test requests
+type testFormFile struct {
+	field    string
+	fileName string
+	content  []byte
+}
+
+// newMultipartRequest builds a multipart/form-data POST request holding the given files
+func newMultipartRequest(t *testing.T, files ...testFormFile) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, f := range files {
+		part, err := writer.CreateFormFile(f.field, f.fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(f.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return request
+}
+
+// readTestFile returns the contents of a file in testdata
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile("./testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
 func TestTools_CheckFileType(t *testing.T) {
 	var tools Tools
 
@@ -293,6 +340,118 @@ func TestTools_UploadFile(t *testing.T) {
 	_ = os.Remove(target)
 }
 
+var streamUploadTests = []struct {
+	name          string
+	files         []testFormFile
+	expectedFiles int
+	errorExpected bool
+}{
+	{name: "single image", files: []testFormFile{{field: "file", fileName: "cyborg-ape.png", content: []byte("\x89PNG\r\n\x1a\n0000")}}, expectedFiles: 1},
+	{name: "two images", files: []testFormFile{{field: "a", fileName: "a.png", content: []byte("\x89PNG\r\n\x1a\n0000")}, {field: "b", fileName: "b.pdf", content: []byte("%PDF-1.4")}}, expectedFiles: 2},
+	{name: "rejected first", files: []testFormFile{{field: "a", fileName: "a.txt", content: []byte("plain text")}, {field: "b", fileName: "b.pdf", content: []byte("%PDF-1.4")}}, expectedFiles: 0, errorExpected: true},
+	{name: "rejected second", files: []testFormFile{{field: "a", fileName: "a.pdf", content: []byte("%PDF-1.4")}, {field: "b", fileName: "b.txt", content: []byte("plain text")}}, expectedFiles: 1, errorExpected: true},
+}
+
+func TestTools_UploadFilesStream(t *testing.T) {
+	for _, entry := range streamUploadTests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store, StreamUploads: true}
+
+		request := newMultipartRequest(t, entry.files...)
+		uploadedFiles, err := testTools.UploadFiles(request, "uploads")
+
+		if err != nil && !entry.errorExpected {
+			t.Errorf("%s: unexpected error: %s", entry.name, err.Error())
+		}
+
+		if err == nil && entry.errorExpected {
+			t.Errorf("%s: error expected but none received", entry.name)
+		}
+
+		if len(uploadedFiles) != entry.expectedFiles {
+			t.Errorf("%s: expected %d files, got %d", entry.name, entry.expectedFiles, len(uploadedFiles))
+		}
+
+		names, _ := store.List("uploads")
+		if len(names) != entry.expectedFiles {
+			t.Errorf("%s: expected %d stored files, got %d", entry.name, entry.expectedFiles, len(names))
+		}
+	}
+}
+
+// plainStorage hides the Rename method of the wrapped Storage
+type plainStorage struct {
+	Storage
+}
+
+var atomicUploadTests = []struct {
+	name          string
+	atomic        bool
+	stream        bool
+	store         Storage
+	expectedFiles int
+	expectedKept  int
+}{
+	{name: "not atomic", atomic: false, store: NewMemoryStorage(), expectedFiles: 2, expectedKept: 2},
+	{name: "atomic", atomic: true, store: NewMemoryStorage(), expectedFiles: 0, expectedKept: 0},
+	{name: "atomic stream", atomic: true, stream: true, store: NewMemoryStorage(), expectedFiles: 0, expectedKept: 0},
+	{name: "not atomic without rename", atomic: false, store: plainStorage{NewMemoryStorage()}, expectedFiles: 2, expectedKept: 2},
+	{name: "atomic without rename", atomic: true, store: plainStorage{NewMemoryStorage()}, expectedFiles: 0, expectedKept: 0},
+}
+
+func TestTools_UploadFilesAtomic(t *testing.T) {
+	for _, entry := range atomicUploadTests {
+		testTools := Tools{Storage: entry.store, AtomicUploads: entry.atomic, StreamUploads: entry.stream}
+
+		request := newMultipartRequest(t,
+			testFormFile{field: "file", fileName: "a.pdf", content: []byte("%PDF-1.4")},
+			testFormFile{field: "file", fileName: "b.pdf", content: []byte("%PDF-1.4")},
+			testFormFile{field: "file", fileName: "c.txt", content: []byte("plain text")},
+		)
+
+		uploadedFiles, err := testTools.UploadFiles(request, "uploads")
+		if err == nil {
+			t.Errorf("%s: error expected but none received", entry.name)
+		}
+
+		if len(uploadedFiles) != entry.expectedFiles {
+			t.Errorf("%s: expected %d files returned, got %d", entry.name, entry.expectedFiles, len(uploadedFiles))
+		}
+
+		names, _ := entry.store.List("uploads")
+		if len(names) != entry.expectedKept {
+			t.Errorf("%s: expected %d files kept, got %v", entry.name, entry.expectedKept, names)
+		}
+
+		for _, name := range names {
+			if strings.HasSuffix(name, tempFileSuffix) {
+				t.Errorf("%s: temporary file left behind: %s", entry.name, name)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesAtomicSuccess(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store, AtomicUploads: true}
+
+	request := newMultipartRequest(t,
+		testFormFile{field: "file", fileName: "a.pdf", content: []byte("%PDF-1.4")},
+		testFormFile{field: "file", fileName: "b.pdf", content: []byte("%PDF-1.4")},
+	)
+
+	uploadedFiles, err := testTools.UploadFiles(request, "uploads", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range uploadedFiles {
+		if _, err := store.Stat("uploads/" + f.NewFileName); err != nil {
+			t.Errorf("expected %s to be stored: %v", f.NewFileName, err)
+		}
+	}
+}
+
 var readJSONTests = []struct {
 	name          string
 	json          string
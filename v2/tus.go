@@ -0,0 +1,595 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tusVersion is the version of the tus protocol implemented by TusHandler
+	tusVersion = "1.0.0"
+
+	// tusExtensions are the tus protocol extensions supported by TusHandler
+	tusExtensions = "creation,expiration,termination"
+
+	// tusDir is the directory below the upload directory that holds unfinished tus uploads
+	tusDir = ".tus"
+
+	// defaultTusExpiration is how long an unfinished tus upload is kept when TusHandler.Expiration is not set
+	defaultTusExpiration = 24 * time.Hour
+)
+
+// tusIDPattern matches the upload IDs generated by TusHandler
+var tusIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// TusHandler is an http.Handler implementing the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload), with the creation, expiration and termination
+// extensions. Received chunks are kept in the Tools storage; when an upload is complete it is
+// checked and saved to UploadDir exactly like a file sent to UploadFiles.
+type TusHandler struct {
+	Tools      *Tools
+	UploadDir  string
+	BasePath   string        // URL path the handler is mounted at, e.g. "/files/"
+	RenameFile bool          // give completed files a random name, as UploadFiles does by default
+	Expiration time.Duration // how long unfinished uploads are kept, 24 hours if not set
+
+	// Completed, if set, is called with the request that finished an upload and the saved file
+	Completed func(r *http.Request, uploadedFile *UploadedFile)
+
+	mu    sync.Mutex
+	locks map[string]bool // uploads held by a request, kept only while it runs
+}
+
+// tusUpload is the state of a single tus upload, saved as JSON next to its chunks
+type tusUpload struct {
+	ID       string
+	Length   int64
+	Offset   int64
+	Metadata map[string]string
+	Expires  time.Time
+	File     *UploadedFile `json:",omitempty"`
+}
+
+// NewTusHandler returns a TusHandler that saves completed uploads to uploadDir with random names.
+// basePath is the URL path the handler is mounted at.
+func (t *Tools) NewTusHandler(uploadDir, basePath string) *TusHandler {
+	return &TusHandler{
+		Tools:      t,
+		UploadDir:  uploadDir,
+		BasePath:   basePath,
+		RenameFile: true,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = override
+	}
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.Tools.maxFileSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+
+	if id == "" {
+		if method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r)
+		return
+	}
+
+	if !tusIDPattern.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch method {
+	case http.MethodHead:
+		h.head(w, r, id)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// create handles POST requests, registering a new upload
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "missing or invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+
+	if length > h.Tools.maxFileSize() {
+		http.Error(w, (&UploadLimitError{Limit: LimitFileSize, Max: h.Tools.maxFileSize()}).Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := newTusID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	upload := &tusUpload{
+		ID:       id,
+		Length:   length,
+		Metadata: metadata,
+		Expires:  time.Now().Add(h.expiration()).UTC(),
+	}
+
+	if err := h.save(upload); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(h.BasePath, "/")+"/"+id)
+	w.Header().Set("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head handles HEAD requests, reporting the offset of an upload
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	upload, err := h.load(id)
+	if err != nil {
+		h.loadError(w, r, id, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+	if len(upload.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatTusMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch handles PATCH requests, appending a chunk to an upload
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "missing or invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	if !h.lock(id) {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	upload, err := h.load(id)
+	if err != nil {
+		h.loadError(w, r, id, err)
+		return
+	}
+
+	if offset != upload.Offset {
+		http.Error(w, fmt.Sprintf("upload offset is %d", upload.Offset), http.StatusConflict)
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	body := io.LimitReader(r.Body, remaining+1)
+
+	chunk := h.chunkName(upload.ID, upload.Offset)
+	n, err := h.Tools.storage().Put(chunk, body)
+	if err == nil && n > remaining {
+		err = errors.New("chunk exceeds Upload-Length")
+	}
+	if err != nil {
+		// the client resumes from the last stored offset
+		_ = h.Tools.storage().Delete(chunk)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n == 0 {
+		_ = h.Tools.storage().Delete(chunk)
+	}
+
+	// reject a disallowed file type as soon as enough is stored to sniff it, rather than after
+	// the whole upload, however small the chunks are
+	sniffSize := min(upload.Length, 512)
+	if upload.Offset < sniffSize && upload.Offset+n >= sniffSize {
+		if err := h.checkType(upload, sniffSize); err != nil {
+			var typeErr *FileTypeError
+			if errors.As(err, &typeErr) {
+				h.delete(upload.ID)
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			_ = h.Tools.storage().Delete(chunk)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	upload.Offset += n
+	if err := h.save(upload); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Offset == upload.Length && upload.File == nil {
+		if status, err := h.complete(r, upload); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate handles DELETE requests, removing an upload and its chunks
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	if _, err := h.load(id); err != nil {
+		h.loadError(w, r, id, err)
+		return
+	}
+
+	h.delete(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete joins the chunks of a finished upload and saves them like any other uploaded file.
+// On error it returns the HTTP status to report, and the upload is removed.
+func (h *TusHandler) complete(r *http.Request, upload *tusUpload) (int, error) {
+	chunks, err := h.chunks(upload.ID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	src := &chunkReader{storage: h.Tools.storage(), names: chunks}
+	defer src.Close()
+
+	fileHeader := &multipart.FileHeader{Filename: tusFileName(upload), Size: upload.Length}
+	batch := &uploadBatch{request: r}
+	uploadedFile, err := h.Tools.saveFile(src, "", fileHeader, h.UploadDir, h.RenameFile, batch)
+	uploadedFile, err = h.Tools.completeFile(batch, uploadedFile, err)
+	if err != nil {
+		h.delete(upload.ID)
+
+		var limitErr *UploadLimitError
+		if errors.As(err, &limitErr) {
+			return http.StatusRequestEntityTooLarge, err
+		}
+
+		var typeErr *FileTypeError
+		if errors.As(err, &typeErr) {
+			return http.StatusUnsupportedMediaType, err
+		}
+		return http.StatusUnprocessableEntity, err
+	}
+
+	// keep the state until it expires so clients can still see the upload is complete
+	for _, name := range chunks {
+		_ = h.Tools.storage().Delete(name)
+	}
+	upload.File = uploadedFile
+	if err := h.save(upload); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if h.Completed != nil {
+		h.Completed(r, uploadedFile)
+	}
+
+	return http.StatusNoContent, nil
+}
+
+// checkType checks the type of upload against the allowed types, sniffing its first size bytes
+func (h *TusHandler) checkType(upload *tusUpload, size int64) error {
+	chunks, err := h.chunks(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	src := &chunkReader{storage: h.Tools.storage(), names: chunks}
+	defer src.Close()
+
+	buff := make([]byte, size)
+	n, err := io.ReadFull(src, buff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	fileName := tusFileName(upload)
+	return h.Tools.checkSniffedType("", fileName, refineFileType(fileName, h.Tools.DetectFileType(buff[:n])))
+}
+
+// chunks returns the storage names of the chunks of upload id, in order
+func (h *TusHandler) chunks(id string) ([]string, error) {
+	names, err := h.Tools.storage().List(h.uploadPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []string
+	for _, name := range names {
+		if filepath.Base(name) != "info" {
+			chunks = append(chunks, name)
+		}
+	}
+
+	return chunks, nil
+}
+
+// tusFileName returns the file name sent in the metadata of upload, or its ID if there is none
+func tusFileName(upload *tusUpload) string {
+	fileName := upload.Metadata["filename"]
+	if fileName == "" {
+		fileName = upload.Metadata["name"]
+	}
+	if fileName == "" {
+		return upload.ID
+	}
+
+	return filepath.Base(fileName)
+}
+
+// CleanupExpired removes all uploads, finished or not, whose expiration time has passed
+func (h *TusHandler) CleanupExpired() error {
+	names, err := h.Tools.storage().List(filepath.Join(h.UploadDir, tusDir))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if filepath.Base(name) != "info" {
+			continue
+		}
+
+		id := filepath.Base(filepath.Dir(name))
+		if _, err := h.load(id); errors.Is(err, errTusExpired) {
+			h.delete(id)
+		}
+	}
+
+	return nil
+}
+
+// errTusExpired is returned by load for uploads past their expiration time
+var errTusExpired = errors.New("upload has expired")
+
+// loadError writes the response for an error returned by load
+func (h *TusHandler) loadError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	switch {
+	case errors.Is(err, errTusExpired):
+		h.delete(id)
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// expiration returns the configured expiration, or the default
+func (h *TusHandler) expiration() time.Duration {
+	if h.Expiration == 0 {
+		return defaultTusExpiration
+	}
+
+	return h.Expiration
+}
+
+// uploadPath returns the storage directory holding the state and chunks of upload id
+func (h *TusHandler) uploadPath(id string) string {
+	return filepath.Join(h.UploadDir, tusDir, id)
+}
+
+// chunkName returns the storage name of the chunk of upload id starting at offset.
+// The offset is zero padded so that chunks list in order.
+func (h *TusHandler) chunkName(id string, offset int64) string {
+	return filepath.Join(h.uploadPath(id), fmt.Sprintf("%020d", offset))
+}
+
+// lock takes upload id for the current request, reporting false if another request holds it.
+// Only held uploads have an entry, so finished, deleted and unknown uploads leave nothing behind.
+func (h *TusHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.locks[id] {
+		return false
+	}
+	if h.locks == nil {
+		h.locks = make(map[string]bool)
+	}
+	h.locks[id] = true
+
+	return true
+}
+
+// unlock releases upload id taken by lock
+func (h *TusHandler) unlock(id string) {
+	h.mu.Lock()
+	delete(h.locks, id)
+	h.mu.Unlock()
+}
+
+// load reads the state of upload id from storage
+func (h *TusHandler) load(id string) (*tusUpload, error) {
+	file, err := h.Tools.storage().Get(filepath.Join(h.uploadPath(id), "info"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var upload tusUpload
+	if err := json.NewDecoder(file).Decode(&upload); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(upload.Expires) {
+		return &upload, errTusExpired
+	}
+
+	return &upload, nil
+}
+
+// save writes the state of upload to storage
+func (h *TusHandler) save(upload *tusUpload) error {
+	out, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Tools.storage().Put(filepath.Join(h.uploadPath(upload.ID), "info"), bytes.NewReader(out))
+	return err
+}
+
+// delete removes the state and chunks of upload id
+func (h *TusHandler) delete(id string) {
+	names, _ := h.Tools.storage().List(h.uploadPath(id))
+	for _, name := range names {
+		_ = h.Tools.storage().Delete(name)
+	}
+}
+
+// newTusID returns a random upload ID
+func newTusID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated keys, each followed by
+// an optional space and base64 encoded value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// formatTusMetadata encodes metadata as an Upload-Metadata header
+func formatTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// chunkReader reads a sequence of stored chunks as one stream, opening each chunk only when needed
+type chunkReader struct {
+	storage Storage
+	names   []string
+	current io.ReadCloser
+}
+
+// Read implements io.Reader
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.names) == 0 {
+				return 0, io.EOF
+			}
+
+			file, err := c.storage.Get(c.names[0])
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.names = file, c.names[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			_ = c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// Close closes the chunk currently being read
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+
+	return c.current.Close()
+}
@@ -0,0 +1,271 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// tusRequest sends a tus request to h and returns the recorded response
+func tusRequest(h http.Handler, method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// createTusUpload creates an upload of the given length and returns its URL
+func createTusUpload(t *testing.T, h http.Handler, length int, fileName string) string {
+	t.Helper()
+
+	rr := tusRequest(h, http.MethodPost, "/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(fileName)) + ",private",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	return rr.Header().Get("Location")
+}
+
+func TestTusHandler_Upload(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+	handler := testTools.NewTusHandler("uploads", "/files/")
+
+	var completed *UploadedFile
+	handler.Completed = func(r *http.Request, uploadedFile *UploadedFile) {
+		completed = uploadedFile
+	}
+
+	rr := tusRequest(handler, http.MethodOptions, "/files/", nil, nil)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("unexpected OPTIONS response %d %v", rr.Code, rr.Header())
+	}
+
+	content := readTestFile(t, "cyborg-ape.png")
+	location := createTusUpload(t, handler, len(content), "cyborg-ape.png")
+
+	rr = tusRequest(handler, http.MethodHead, location, nil, nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Upload-Offset") != "0" {
+		t.Fatalf("unexpected HEAD response %d offset %s", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+	if meta, _ := parseTusMetadata(rr.Header().Get("Upload-Metadata")); meta["filename"] != "cyborg-ape.png" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+
+	patch := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	rr = tusRequest(handler, http.MethodPatch, location, content[:1000], patch)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "1000" {
+		t.Fatalf("unexpected PATCH response %d offset %s", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+
+	// resending from a stale offset is a conflict
+	rr = tusRequest(handler, http.MethodPatch, location, content[:1000], patch)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rr.Code)
+	}
+
+	patch["Upload-Offset"] = "1000"
+	rr = tusRequest(handler, http.MethodPatch, location, content[1000:], patch)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("unexpected PATCH response %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if completed == nil {
+		t.Fatal("expected Completed to be called")
+	}
+
+	if completed.OriginalFileName != "cyborg-ape.png" || completed.FileSize != int64(len(content)) || completed.Width != 400 {
+		t.Errorf("unexpected uploaded file %+v", completed)
+	}
+
+	if _, err := store.Stat("uploads/" + completed.NewFileName); err != nil {
+		t.Errorf("expected completed file to be stored: %v", err)
+	}
+
+	rr = tusRequest(handler, http.MethodHead, location, nil, nil)
+	if rr.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Errorf("expected HEAD to report the upload as complete, got offset %s", rr.Header().Get("Upload-Offset"))
+	}
+
+	rr = tusRequest(handler, http.MethodDelete, location, nil, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", rr.Code)
+	}
+
+	rr = tusRequest(handler, http.MethodHead, location, nil, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after termination, got %d", rr.Code)
+	}
+
+	if names, _ := store.List("uploads/.tus"); len(names) != 0 {
+		t.Errorf("expected upload state to be removed, found %v", names)
+	}
+}
+
+var tusErrorTests = []struct {
+	name           string
+	method         string
+	target         string
+	body           []byte
+	headers        map[string]string
+	skipVersion    bool
+	expectedStatus int
+}{
+	{name: "missing version", method: http.MethodPost, target: "/files/", headers: map[string]string{"Upload-Length": "10"}, skipVersion: true, expectedStatus: http.StatusPreconditionFailed},
+	{name: "missing length", method: http.MethodPost, target: "/files/", expectedStatus: http.StatusBadRequest},
+	{name: "too large", method: http.MethodPost, target: "/files/", headers: map[string]string{"Upload-Length": "2000"}, expectedStatus: http.StatusRequestEntityTooLarge},
+	{name: "bad metadata", method: http.MethodPost, target: "/files/", headers: map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename !!!"}, expectedStatus: http.StatusBadRequest},
+	{name: "unknown upload", method: http.MethodHead, target: "/files/0123456789abcdef0123456789abcdef", expectedStatus: http.StatusNotFound},
+	{name: "invalid id", method: http.MethodHead, target: "/files/../secret", expectedStatus: http.StatusNotFound},
+	{name: "wrong method", method: http.MethodGet, target: "/files/", expectedStatus: http.StatusMethodNotAllowed},
+}
+
+func TestTusHandler_Errors(t *testing.T) {
+	testTools := Tools{Storage: NewMemoryStorage(), MaxFileSize: 1000}
+	handler := testTools.NewTusHandler("uploads", "/files/")
+
+	for _, entry := range tusErrorTests {
+		req := httptest.NewRequest(entry.method, entry.target, nil)
+		if !entry.skipVersion {
+			req.Header.Set("Tus-Resumable", tusVersion)
+		}
+		for key, value := range entry.headers {
+			req.Header.Set(key, value)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != entry.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", entry.name, entry.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestTusHandler_FileTypeNotPermitted(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+	handler := testTools.NewTusHandler("uploads", "/files/")
+
+	content := []byte("just some plain text")
+	location := createTusUpload(t, handler, len(content), "notes.txt")
+
+	rr := tusRequest(handler, http.MethodPatch, location, content, map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"})
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", rr.Code)
+	}
+
+	if names, _ := store.List("uploads"); len(names) != 0 {
+		t.Errorf("expected rejected upload to be removed, found %v", names)
+	}
+}
+
+func TestTusHandler_SmallChunks(t *testing.T) {
+	plainText := bytes.Repeat([]byte("plain text "), 60)
+
+	var tests = []struct {
+		name           string
+		fileName       string
+		content        []byte
+		chunks         []int
+		expectedStatus []int
+	}{
+		{name: "pdf", fileName: "doc.pdf", content: pdfBytes, chunks: []int{4, 600, 396}, expectedStatus: []int{http.StatusNoContent, http.StatusNoContent, http.StatusNoContent}},
+		{name: "text", fileName: "notes.txt", content: plainText, chunks: []int{4, 596, 60}, expectedStatus: []int{http.StatusNoContent, http.StatusUnsupportedMediaType}},
+		{name: "short text", fileName: "notes.txt", content: plainText[:20], chunks: []int{4, 16}, expectedStatus: []int{http.StatusNoContent, http.StatusUnsupportedMediaType}},
+	}
+
+	for _, e := range tests {
+		store := NewMemoryStorage()
+		testTools := Tools{Storage: store}
+		handler := testTools.NewTusHandler("uploads", "/files/")
+
+		location := createTusUpload(t, handler, len(e.content), e.fileName)
+
+		offset := 0
+		for i, status := range e.expectedStatus {
+			chunk := e.content[offset : offset+e.chunks[i]]
+			rr := tusRequest(handler, http.MethodPatch, location, chunk, map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": strconv.Itoa(offset)})
+			if rr.Code != status {
+				t.Errorf("%s: expected status %d for chunk %d, got %d: %s", e.name, status, i, rr.Code, rr.Body.String())
+			}
+			offset += len(chunk)
+		}
+
+		names, _ := store.List("uploads")
+		if last := e.expectedStatus[len(e.expectedStatus)-1]; last == http.StatusNoContent && len(names) == 0 {
+			t.Errorf("%s: expected the file to be saved", e.name)
+		} else if last != http.StatusNoContent && len(names) != 0 {
+			t.Errorf("%s: expected rejected upload to be removed, found %v", e.name, names)
+		}
+		if len(handler.locks) != 0 {
+			t.Errorf("%s: expected no locks to be kept after the upload, got %d", e.name, len(handler.locks))
+		}
+	}
+}
+
+func TestTusHandler_UnknownIDLocks(t *testing.T) {
+	testTools := Tools{Storage: NewMemoryStorage()}
+	handler := testTools.NewTusHandler("uploads", "/files/")
+
+	for i := 0; i < 10; i++ {
+		id, err := newTusID()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rr := tusRequest(handler, http.MethodPatch, "/files/"+id, []byte("x"), map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for PATCH, got %d", rr.Code)
+		}
+		if rr := tusRequest(handler, http.MethodDelete, "/files/"+id, nil, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("expected status 404 for DELETE, got %d", rr.Code)
+		}
+	}
+
+	if len(handler.locks) != 0 {
+		t.Errorf("expected no locks to be kept for unknown uploads, got %d", len(handler.locks))
+	}
+}
+
+func TestTusHandler_Expiration(t *testing.T) {
+	store := NewMemoryStorage()
+	testTools := Tools{Storage: store}
+	handler := testTools.NewTusHandler("uploads", "/files/")
+	handler.Expiration = time.Millisecond
+
+	first := createTusUpload(t, handler, 10, "a.pdf")
+	_ = createTusUpload(t, handler, 10, "b.pdf")
+	time.Sleep(5 * time.Millisecond)
+
+	rr := tusRequest(handler, http.MethodHead, first, nil, nil)
+	if rr.Code != http.StatusGone {
+		t.Errorf("expected status 410, got %d", rr.Code)
+	}
+
+	if err := handler.CleanupExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, _ := store.List("uploads"); len(names) != 0 {
+		t.Errorf("expected expired uploads to be removed, found %v", names)
+	}
+}